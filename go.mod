@@ -22,6 +22,8 @@ require (
 	github.com/swaggest/jsonschema-go v0.3.51
 	github.com/twpayne/go-vfs/v4 v4.2.0
 	github.com/zcalusic/sysinfo v1.0.1
+	golang.org/x/crypto v0.11.0
+	golang.org/x/sys v0.10.0
 	gopkg.in/yaml.v1 v1.0.0-20140924161607-9f9df34309c0
 	gopkg.in/yaml.v2 v2.4.0
 	gopkg.in/yaml.v3 v3.0.1
@@ -83,7 +85,6 @@ require (
 	golang.org/x/mod v0.10.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sync v0.2.0 // indirect
-	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/term v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	golang.org/x/tools v0.9.3 // indirect
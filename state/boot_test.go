@@ -0,0 +1,82 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Boot", func() {
+	Describe("DetectBootWithVFS", func() {
+		detect := func(cmdline string) Boot {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": cmdline})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			boot, err := DetectBootWithVFS(fs)
+			Expect(err).ToNot(HaveOccurred())
+			return boot
+		}
+
+		It("detects active boot", func() {
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_ACTIVE panic=5")).To(Equal(Active))
+		})
+
+		It("detects recovery boot", func() {
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_RECOVERY cos-img/filename=/cOS/recovery.img panic=5")).To(Equal(Recovery))
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_SYSTEM panic=5")).To(Equal(Recovery))
+		})
+
+		It("detects reset boot instead of recovery", func() {
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_RECOVERY cos-img/filename=/cOS/recovery.img panic=5 kairos.reset")).To(Equal(Reset))
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_SYSTEM kairos.reset panic=5")).To(Equal(Reset))
+		})
+
+		It("detects livecd boot", func() {
+			Expect(detect("BOOT_IMAGE=/boot/kernel root=live:CDLABEL=COS_LIVE rd.live.dir=/ rd.live.squashimg=rootfs.squashfs")).To(Equal(LiveCD))
+		})
+
+		It("only looks at the root and the flags, not at any mention of the labels", func() {
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=/dev/sda2 foo=xCOS_ACTIVE")).To(Equal(Unknown))
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_ACTIVE rd.kairos.reset_log=1")).To(Equal(Active))
+			Expect(detect("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_RECOVERY nokairos.reset")).To(Equal(Recovery))
+		})
+
+		It("fails if the cmdline can't be read", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			boot, err := DetectBootWithVFS(fs)
+			Expect(err).To(HaveOccurred())
+			Expect(boot).To(Equal(Unknown))
+		})
+	})
+
+	Describe("DetectBoot", func() {
+		It("detects the boot state of the running system", func() {
+			expected, expectedErr := DetectBootWithVFS(vfs.OSFS)
+			boot, err := DetectBoot()
+			Expect(boot).To(Equal(expected))
+			if expectedErr != nil {
+				Expect(err).To(MatchError(expectedErr.Error()))
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	})
+
+	Describe("AllBootStates", func() {
+		It("lists valid states only", func() {
+			Expect(AllBootStates()).To(ContainElements(Active, Passive, Recovery, Reset, LiveCD, Unknown))
+			for _, b := range AllBootStates() {
+				Expect(b.Valid()).To(BeTrue())
+			}
+		})
+
+		It("rejects undefined states", func() {
+			Expect(Boot("").Valid()).To(BeFalse())
+			Expect(Boot("uki_boot").Valid()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package state
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// loaderVendorGUID is the vendor GUID of the variables systemd-boot exports, like LoaderEntrySelected
+const loaderVendorGUID = "4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+
+// bootCounterRegexp matches the counters systemd-boot keeps in the entry file names, like entry+3.conf for 3 tries
+// left or entry+1-2.conf for 1 left after 2 failed
+var bootCounterRegexp = regexp.MustCompile(`^(.+)\+(\d+)(?:-(\d+))?\.conf$`)
+
+// DetectBootCountersWithVFS reads the boot counters of the entry systemd-boot booted from the loader entries using
+// a vfs so it can be used for tests as well. The entry is the one in LoaderEntrySelected, or the only one with
+// counters if that can't be read. Both are -1 when there are no counters, which is also the case once the boot was
+// blessed as good.
+func DetectBootCountersWithVFS(fs vfs.FS) (left, total int) {
+	selected := ""
+	if dat, err := readVendorEFIVar(fs, loaderVendorGUID, "LoaderEntrySelected"); err == nil {
+		selected = strings.TrimSuffix(efiString(dat), ".conf")
+	}
+
+	counted := [][]string{}
+	for _, dir := range loaderEntriesDirs {
+		files, err := fs.Glob(filepath.Join(dir, "*.conf"))
+		if err != nil {
+			continue
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			if m := bootCounterRegexp.FindStringSubmatch(filepath.Base(f)); m != nil {
+				counted = append(counted, m)
+			}
+		}
+	}
+
+	for _, m := range counted {
+		if (selected == "" && len(counted) == 1) || m[1] == selected {
+			left, _ = strconv.Atoi(m[2])
+			done, _ := strconv.Atoi(m[3])
+			return left, left + done
+		}
+	}
+	return -1, -1
+}
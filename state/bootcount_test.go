@@ -0,0 +1,74 @@
+package state_test
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+const loaderGUID = "4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+
+// efiString builds the content of an efivarfs file holding a NUL terminated UCS-2 string
+func efiString(s string) string {
+	dat := []byte{0x06, 0x00, 0x00, 0x00}
+	for _, c := range utf16.Encode([]rune(s)) {
+		dat = binary.LittleEndian.AppendUint16(dat, c)
+	}
+	return string(append(dat, 0x00, 0x00))
+}
+
+var _ = Describe("DetectBootCountersWithVFS", func() {
+	It("reads the counters of the selected entry", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/efi/loader/entries/active+1-2.conf":                         "title Kairos",
+			"/efi/loader/entries/passive+3.conf":                          "title Kairos (fallback)",
+			"/efi/loader/entries/recovery.conf":                           "title Kairos recovery",
+			"/sys/firmware/efi/efivars/LoaderEntrySelected-" + loaderGUID: efiString("active.conf"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		left, max := DetectBootCountersWithVFS(fs)
+		Expect(left).To(Equal(1))
+		Expect(max).To(Equal(3))
+	})
+
+	It("takes the only entry with counters when the selected one is unknown", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/boot/loader/entries/active+3.conf": "title Kairos",
+			"/boot/loader/entries/recovery.conf": "title Kairos recovery",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		left, max := DetectBootCountersWithVFS(fs)
+		Expect(left).To(Equal(3))
+		Expect(max).To(Equal(3))
+	})
+
+	It("is unknown once the entry was blessed or without systemd-boot", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/efi/loader/entries/active.conf":                             "title Kairos",
+			"/efi/loader/entries/passive+0-3.conf":                        "title Kairos (fallback)",
+			"/sys/firmware/efi/efivars/LoaderEntrySelected-" + loaderGUID: efiString("active"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		left, max := DetectBootCountersWithVFS(fs)
+		Expect(left).To(Equal(-1))
+		Expect(max).To(Equal(-1))
+
+		grubFS, grubCleanup, err := vfst.NewTestFS(map[string]interface{}{"/boot/grub2/grub.cfg": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer grubCleanup()
+
+		left, max = DetectBootCountersWithVFS(grubFS)
+		Expect(left).To(Equal(-1))
+		Expect(max).To(Equal(-1))
+	})
+})
@@ -0,0 +1,201 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// BootEntry is a kernel the bootloader offers to boot
+type BootEntry struct {
+	Title   string `yaml:"title" json:"title"`
+	Kernel  string `yaml:"kernel" json:"kernel"`
+	Initrd  string `yaml:"initrd,omitempty" json:"initrd,omitempty"`
+	Options string `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+// loaderEntriesDirs are where systemd-boot reads its entries from
+var loaderEntriesDirs = []string{"/boot/loader/entries", "/efi/loader/entries"}
+
+// grubConfigs are the grub configs looked up on the root and on the state partition
+var grubConfigs = []string{"/boot/grub2/grub.cfg", "/boot/grub/grub.cfg"}
+
+// BootEntries lists the boot entries from the systemd-boot entries or, if there are none, the grub menu entries.
+// It returns no entries when no recognizable bootloader config is found.
+func (r Runtime) BootEntries() ([]BootEntry, error) {
+	fs := r.filesystem()
+	entries := []BootEntry{}
+	for _, dir := range loaderEntriesDirs {
+		files, err := fs.Glob(filepath.Join(dir, "*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			dat, err := fs.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			entry := ParseLoaderEntry(dat)
+			if entry.Title == "" {
+				entry.Title = strings.TrimSuffix(filepath.Base(f), ".conf")
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) > 0 {
+		return entries, nil
+	}
+
+	configs := append([]string{}, grubConfigs...)
+	if r.State.Mounted {
+		configs = append(configs, filepath.Join(r.State.MountPoint, "grub2/grub.cfg"), filepath.Join(r.State.MountPoint, "grub/grub.cfg"))
+	}
+	for _, c := range configs {
+		dat, err := fs.ReadFile(c)
+		if err != nil {
+			continue
+		}
+		return ParseGrubMenuEntries(dat), nil
+	}
+	return entries, nil
+}
+
+// defaultBootEntry returns the entry the bootloader boots when nothing is picked in its menu. For systemd-boot
+// that's the first entry matching the default of loader.conf, for grub the next_entry or saved_entry of the
+// grubenv, by title or index. It falls back to the first entry otherwise.
+func (r Runtime) defaultBootEntry() (BootEntry, error) {
+	fs := r.filesystem()
+	for _, dir := range loaderEntriesDirs {
+		files, err := fs.Glob(filepath.Join(dir, "*.conf"))
+		if err != nil {
+			return BootEntry{}, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		sort.Strings(files)
+		chosen := files[0]
+		if dat, err := fs.ReadFile(filepath.Join(filepath.Dir(dir), "loader.conf")); err == nil {
+			if pattern := loaderDefault(dat); pattern != "" {
+				for _, f := range files {
+					if ok, _ := filepath.Match(pattern, filepath.Base(f)); ok {
+						chosen = f
+						break
+					}
+				}
+			}
+		}
+		dat, err := fs.ReadFile(chosen)
+		if err != nil {
+			return BootEntry{}, err
+		}
+		return ParseLoaderEntry(dat), nil
+	}
+
+	entries, err := r.BootEntries()
+	if err != nil {
+		return BootEntry{}, err
+	}
+	if len(entries) == 0 {
+		return BootEntry{}, fmt.Errorf("no boot entries found")
+	}
+	env, _ := r.GrubEnv()
+	for _, k := range []string{"next_entry", "saved_entry"} {
+		selected := env[k]
+		if selected == "" {
+			continue
+		}
+		for i, e := range entries {
+			if e.Title == selected || strconv.Itoa(i) == selected {
+				return e, nil
+			}
+		}
+	}
+	return entries[0], nil
+}
+
+// loaderDefault returns the default entry pattern of a loader.conf, it's empty if there is none
+func loaderDefault(dat []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if k == "default" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// ParseLoaderEntry parses a systemd-boot entry file. Multiple initrd lines are joined with spaces.
+func ParseLoaderEntry(dat []byte) BootEntry {
+	entry := BootEntry{}
+	initrds := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// the key is separated from the value by any run of whitespace, often a tab
+		k := strings.Fields(line)[0]
+		v := strings.TrimSpace(strings.TrimPrefix(line, k))
+		switch k {
+		case "title":
+			entry.Title = v
+		case "linux", "efi":
+			entry.Kernel = v
+		case "initrd":
+			initrds = append(initrds, v)
+		case "options":
+			entry.Options = strings.TrimSpace(strings.Join([]string{entry.Options, v}, " "))
+		}
+	}
+	entry.Initrd = strings.Join(initrds, " ")
+	return entry
+}
+
+// ParseGrubMenuEntries parses the menuentry blocks of a grub config, submenus get flattened
+func ParseGrubMenuEntries(dat []byte) []BootEntry {
+	entries := []BootEntry{}
+	var current *BootEntry
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := shlex.Split(line)
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "menuentry":
+			if current != nil {
+				entries = append(entries, *current)
+			}
+			current = &BootEntry{}
+			if len(fields) > 1 {
+				current.Title = fields[1]
+			}
+		case "linux", "linuxefi", "linux16":
+			if current != nil && len(fields) > 1 {
+				current.Kernel = fields[1]
+				current.Options = strings.Join(fields[2:], " ")
+			}
+		case "initrd", "initrdefi", "initrd16":
+			if current != nil && len(fields) > 1 {
+				current.Initrd = strings.Join(fields[1:], " ")
+			}
+		}
+	}
+	if current != nil {
+		entries = append(entries, *current)
+	}
+	return entries
+}
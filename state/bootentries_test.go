@@ -0,0 +1,73 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var grubCfg = `set timeout=10
+menuentry "Kairos" --id cos {
+  search --no-floppy --label --set=root COS_STATE
+  linux ($root)/boot/vmlinuz root=LABEL=COS_ACTIVE rd.cos.oemlabel=COS_OEM
+  initrd ($root)/boot/initrd
+}
+
+submenu "Fallback" {
+  menuentry 'Kairos (fallback)' --id fallback {
+    linux ($root)/boot/vmlinuz root=LABEL=COS_PASSIVE
+    initrd ($root)/boot/initrd
+  }
+}
+`
+
+var _ = Describe("BootEntries", func() {
+	It("lists systemd-boot entries", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/efi/loader/entries/active.conf":  "title Kairos\nlinux /vmlinuz\ninitrd /initrd\noptions root=LABEL=COS_ACTIVE\n",
+			"/efi/loader/entries/passive.conf": "# no title\nlinux /vmlinuz-passive\ninitrd /microcode\ninitrd /initrd-passive\n",
+			"/boot/grub2/grub.cfg":             grubCfg,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		entries, err := Runtime{}.WithFS(fs).BootEntries()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(Equal([]BootEntry{
+			{Title: "Kairos", Kernel: "/vmlinuz", Initrd: "/initrd", Options: "root=LABEL=COS_ACTIVE"},
+			{Title: "passive", Kernel: "/vmlinuz-passive", Initrd: "/microcode /initrd-passive"},
+		}))
+	})
+
+	It("parses loader entries separated by tabs and runs of spaces", func() {
+		entry := ParseLoaderEntry([]byte("title\tKairos\nlinux   /vmlinuz\ninitrd\t/initrd\noptions\troot=LABEL=COS_ACTIVE  console=tty1\n"))
+		Expect(entry).To(Equal(BootEntry{Title: "Kairos", Kernel: "/vmlinuz", Initrd: "/initrd", Options: "root=LABEL=COS_ACTIVE  console=tty1"}))
+	})
+
+	It("lists grub menu entries from the state partition", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/run/initramfs/cos-state/grub2/grub.cfg": grubCfg,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		r := Runtime{State: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"}}.WithFS(fs)
+		entries, err := r.BootEntries()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(Equal([]BootEntry{
+			{Title: "Kairos", Kernel: "($root)/boot/vmlinuz", Initrd: "($root)/boot/initrd", Options: "root=LABEL=COS_ACTIVE rd.cos.oemlabel=COS_OEM"},
+			{Title: "Kairos (fallback)", Kernel: "($root)/boot/vmlinuz", Initrd: "($root)/boot/initrd", Options: "root=LABEL=COS_PASSIVE"},
+		}))
+	})
+
+	It("returns no entries without a bootloader config", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/boot/vmlinuz": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		entries, err := Runtime{}.WithFS(fs).BootEntries()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
@@ -0,0 +1,26 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectBootloaderWithVFS", func() {
+	DescribeTable("detects the bootloader",
+		func(files map[string]interface{}, expected string) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectBootloaderWithVFS(fs)).To(Equal(expected))
+		},
+		Entry("grub", map[string]interface{}{"/boot/grub2/grub.cfg": ""}, BootloaderGrub),
+		Entry("systemd-boot", map[string]interface{}{"/boot/loader/entries/active.conf": ""}, BootloaderSystemdBoot),
+		Entry("u-boot script", map[string]interface{}{"/boot/boot.scr": ""}, BootloaderUBoot),
+		Entry("u-boot environment", map[string]interface{}{"/boot/uEnv.txt": ""}, BootloaderUBoot),
+		Entry("grub before systemd-boot", map[string]interface{}{"/boot/grub2/grub.cfg": "", "/boot/loader/entries/active.conf": ""}, BootloaderGrub),
+		Entry("unknown", map[string]interface{}{"/boot/vmlinuz": ""}, BootloaderUnknown),
+		Entry("no /boot", map[string]interface{}{"/etc/os-release": ""}, BootloaderUnknown),
+	)
+})
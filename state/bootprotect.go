@@ -0,0 +1,53 @@
+package state
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// bootConfigFiles returns the boot configs that would be tampered with to change how the node boots, which are
+// the same ones BootEntries reads
+func (r Runtime) bootConfigFiles() []string {
+	configs := append([]string{}, grubConfigs...)
+	if r.State.Mounted {
+		configs = append(configs, filepath.Join(r.State.MountPoint, "grub2/grub.cfg"), filepath.Join(r.State.MountPoint, "grub/grub.cfg"))
+	}
+	for _, dir := range loaderEntriesDirs {
+		configs = append(configs, filepath.Join(filepath.Dir(dir), "loader.conf"))
+	}
+	return configs
+}
+
+// bootConfigProtected returns whether every boot config found is either on a read-only mount or immutable
+// (chattr +i). It's false when there is no boot config or it can't be told.
+func (r Runtime) bootConfigProtected() bool {
+	fs := r.filesystem()
+	mounts, err := readMountInfo(fs)
+	if err != nil {
+		return false
+	}
+	found := false
+	for _, c := range r.bootConfigFiles() {
+		if !exists(fs, c) {
+			continue
+		}
+		found = true
+		if m, ok := mountHolding(mounts, c, nil); ok && hasOption(m.Options, "ro") {
+			continue
+		}
+		if !isImmutable(fs, c) {
+			return false
+		}
+	}
+	return found
+}
+
+// hasOption returns whether the comma separated mount options include the given one
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package state
+
+import (
+	"github.com/twpayne/go-vfs/v4"
+	"golang.org/x/sys/unix"
+)
+
+// isImmutable returns whether the file has the immutable attribute set, false if it can't be read
+func isImmutable(fs vfs.FS, path string) bool {
+	raw, err := fs.RawPath(path)
+	if err != nil {
+		return false
+	}
+	var stat unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, raw, 0, 0, &stat); err != nil {
+		return false
+	}
+	return stat.Attributes_mask&unix.STATX_ATTR_IMMUTABLE != 0 && stat.Attributes&unix.STATX_ATTR_IMMUTABLE != 0
+}
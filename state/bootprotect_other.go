@@ -0,0 +1,10 @@
+//go:build !linux
+
+package state
+
+import "github.com/twpayne/go-vfs/v4"
+
+// isImmutable always returns false, the immutable attribute is only read on linux
+func isImmutable(fs vfs.FS, path string) bool {
+	return false
+}
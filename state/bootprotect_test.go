@@ -0,0 +1,42 @@
+package state
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("boot config protection", func() {
+	mountInfo := "22 1 0:21 / / ro,relatime shared:1 - overlay overlay rw\n" +
+		"30 22 8:2 / /run/initramfs/cos-state rw,relatime shared:9 - ext4 /dev/sda2 rw\n"
+	protected := func(files map[string]interface{}) bool {
+		fs, cleanup, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		r := Runtime{State: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"}}.WithFS(fs)
+		return r.bootConfigProtected()
+	}
+
+	It("is protected when the configs are on read-only mounts", func() {
+		Expect(protected(map[string]interface{}{
+			"/proc/self/mountinfo": mountInfo,
+			"/boot/grub2/grub.cfg": "",
+		})).To(BeTrue())
+	})
+
+	It("is not protected when a config is on a writable mount", func() {
+		Expect(protected(map[string]interface{}{
+			"/proc/self/mountinfo":                    mountInfo,
+			"/boot/grub2/grub.cfg":                    "",
+			"/run/initramfs/cos-state/grub2/grub.cfg": "",
+		})).To(BeFalse())
+	})
+
+	It("is not protected without configs", func() {
+		Expect(protected(map[string]interface{}{"/proc/self/mountinfo": mountInfo})).To(BeFalse())
+	})
+
+	It("is not protected when the mounts can't be read", func() {
+		Expect(protected(map[string]interface{}{"/boot/grub2/grub.cfg": ""})).To(BeFalse())
+	})
+})
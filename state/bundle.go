@@ -0,0 +1,123 @@
+package state
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RedactedKeys are the fragments of the keys whose values get redacted from support bundles, matched case-insensitively
+var RedactedKeys = []string{"password", "passphrase", "token", "secret", "apikey", "api_key"}
+
+// redactedValue replaces the values of sensitive keys
+const redactedValue = "<redacted>"
+
+// supportBundleCommands are the commands whose output is added to the support bundle, by file name
+func (r Runtime) supportBundleCommands() map[string]string {
+	return map[string]string{
+		"lsblk.json":   fmt.Sprintf("%s -J -O", r.lsblk()),
+		"findmnt.json": fmt.Sprintf("%s -J", r.findmnt()),
+	}
+}
+
+// SupportBundle writes a tar with everything needed to debug the detection: the runtime as YAML, the raw output of
+// lsblk and findmnt, the kernel cmdline, the mounts and the grub environment. Values of keys matching RedactedKeys
+// are redacted from the cmdline and the grub environment.
+// Artifacts that can't be gathered get the error written in their place, so the bundle is as complete as possible.
+func (r Runtime) SupportBundle(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	add := func(name string, dat []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(dat)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(dat)
+		return err
+	}
+	orError := func(dat []byte, err error) []byte {
+		if err != nil {
+			return []byte(fmt.Sprintf("error: %s\n", err))
+		}
+		return dat
+	}
+
+	runtimeYAML, err := r.YAML(false)
+	if err := add("runtime.yaml", orError(runtimeYAML, err)); err != nil {
+		return err
+	}
+
+	commands := r.supportBundleCommands()
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out, err := r.commandRunner()(context.Background(), commands[name])
+		if err != nil {
+			out = fmt.Sprintf("%s\nerror: %s\n", out, err)
+		}
+		if err := add(name, []byte(out)); err != nil {
+			return err
+		}
+	}
+
+	fs := r.filesystem()
+	cmdline, err := fs.ReadFile("/proc/cmdline")
+	if err == nil {
+		cmdline = []byte(RedactCmdline(string(cmdline)) + "\n")
+	}
+	if err := add("cmdline", orError(cmdline, err)); err != nil {
+		return err
+	}
+	if err := add("mountinfo", orError(fs.ReadFile("/proc/self/mountinfo"))); err != nil {
+		return err
+	}
+
+	var grubenv []byte
+	env, err := r.GrubEnv()
+	if err == nil {
+		keys := []string{}
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := env[k]
+			if isRedacted(k) {
+				v = redactedValue
+			}
+			grubenv = append(grubenv, fmt.Sprintf("%s=%s\n", k, v)...)
+		}
+	}
+	if err := add("grubenv", orError(grubenv, err)); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// RedactCmdline redacts the values of the cmdline arguments whose key matches RedactedKeys
+func RedactCmdline(cmdline string) string {
+	args := strings.Fields(cmdline)
+	for i, a := range args {
+		if k, _, found := strings.Cut(a, "="); found && isRedacted(k) {
+			args[i] = k + "=" + redactedValue
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+func isRedacted(key string) bool {
+	key = strings.ToLower(key)
+	for _, r := range RedactedKeys {
+		if strings.Contains(key, r) {
+			return true
+		}
+	}
+	return false
+}
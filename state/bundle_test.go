@@ -0,0 +1,96 @@
+package state_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("SupportBundle", func() {
+	untar := func(dat []byte) map[string]string {
+		files := map[string]string{}
+		tr := tar.NewReader(bytes.NewReader(dat))
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).ToNot(HaveOccurred())
+			content, err := io.ReadAll(tr)
+			Expect(err).ToNot(HaveOccurred())
+			files[h.Name] = string(content)
+		}
+		return files
+	}
+
+	It("bundles all the artifacts, redacted", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/cmdline":                    "BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_ACTIVE kairos.token=abcd rd.vconsole.keymap=us\n",
+			"/proc/self/mountinfo":             kairosMountInfo,
+			"/run/initramfs/cos-state/grubenv": grubEnvBlock("next_entry=recovery", "registry_password=hunter2"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		runner := func(_ context.Context, command string) (string, error) {
+			if strings.HasPrefix(command, "findmnt") {
+				return "findmnt: not found", errors.New("exit status 127")
+			}
+			return `{"blockdevices": []}`, nil
+		}
+		r := Runtime{
+			BootState: Active,
+			State:     PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"},
+		}.WithFS(fs).WithCommandRunner(runner)
+
+		buf := &bytes.Buffer{}
+		Expect(r.SupportBundle(buf)).To(Succeed())
+		files := untar(buf.Bytes())
+		Expect(files).To(HaveLen(6))
+		Expect(files["runtime.yaml"]).To(Equal(r.String()))
+		Expect(files["lsblk.json"]).To(Equal(`{"blockdevices": []}`))
+		Expect(files["findmnt.json"]).To(ContainSubstring("exit status 127"))
+		Expect(files["cmdline"]).To(Equal("BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_ACTIVE kairos.token=<redacted> rd.vconsole.keymap=us\n"))
+		Expect(files["mountinfo"]).To(Equal(kairosMountInfo))
+		Expect(files["grubenv"]).To(Equal("next_entry=recovery\nregistry_password=<redacted>\n"))
+	})
+
+	It("runs the configured lsblk and findmnt", func() {
+		commands := []string{}
+		runner := func(_ context.Context, command string) (string, error) {
+			commands = append(commands, command)
+			return "{}", nil
+		}
+		r := Runtime{}.WithCommandRunner(runner).WithToolPaths("/opt/bin/lsblk", "/opt/bin/findmnt")
+
+		Expect(r.SupportBundle(&bytes.Buffer{})).To(Succeed())
+		Expect(commands).To(ConsistOf("/opt/bin/lsblk -J -O", "/opt/bin/findmnt -J"))
+	})
+
+	It("records the artifacts that can't be gathered", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/os-release": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		runner := func(_ context.Context, _ string) (string, error) { return "", nil }
+		buf := &bytes.Buffer{}
+		Expect(Runtime{}.WithFS(fs).WithCommandRunner(runner).SupportBundle(buf)).To(Succeed())
+		files := untar(buf.Bytes())
+		Expect(files["cmdline"]).To(HavePrefix("error: "))
+		Expect(files["grubenv"]).To(Equal("error: no grubenv found\n"))
+	})
+})
+
+var _ = Describe("RedactCmdline", func() {
+	It("only redacts sensitive values", func() {
+		Expect(RedactCmdline("console=tty1 install.password=foo cos.setup API_KEY=bar")).To(Equal("console=tty1 install.password=<redacted> cos.setup API_KEY=<redacted>"))
+	})
+})
@@ -0,0 +1,88 @@
+package state
+
+import "time"
+
+// Clone returns a deep copy of the runtime, sharing no slices or maps with it, so it can be handed to other
+// goroutines while the original keeps being updated.
+func (r Runtime) Clone() Runtime {
+	c := r
+	for _, p := range []*PartitionState{&c.Persistent, &c.Recovery, &c.OEM, &c.State} {
+		*p = p.clone()
+	}
+	c.Network.Interfaces = nil
+	if r.Network.Interfaces != nil {
+		c.Network.Interfaces = []NetworkInterface{}
+		for _, i := range r.Network.Interfaces {
+			i.IPv4, i.IPv6, i.Members = cloneStrings(i.IPv4), cloneStrings(i.IPv6), cloneStrings(i.Members)
+			c.Network.Interfaces = append(c.Network.Interfaces, i)
+		}
+	}
+	c.EFIBootOrder = cloneStrings(r.EFIBootOrder)
+	c.Warnings = cloneStrings(r.Warnings)
+	c.StorageDrivers = cloneStrings(r.StorageDrivers)
+	c.Disks = nil
+	if r.Disks != nil {
+		c.Disks = append([]DiskState{}, r.Disks...)
+	}
+	c.ZFSPools = nil
+	if r.ZFSPools != nil {
+		c.ZFSPools = []ZFSPool{}
+		for _, p := range r.ZFSPools {
+			if p.Datasets != nil {
+				p.Datasets = append([]ZFSDataset{}, p.Datasets...)
+			}
+			c.ZFSPools = append(c.ZFSPools, p)
+		}
+	}
+	c.LabelDevices = nil
+	if r.LabelDevices != nil {
+		c.LabelDevices = map[string][]string{}
+		for k, v := range r.LabelDevices {
+			c.LabelDevices[k] = cloneStrings(v)
+		}
+	}
+	c.Extra = nil
+	if r.Extra != nil {
+		c.Extra = map[string]PartitionState{}
+		for k, v := range r.Extra {
+			c.Extra[k] = v.clone()
+		}
+	}
+	c.cmdline = nil
+	if r.cmdline != nil {
+		c.cmdline = map[string]string{}
+		for k, v := range r.cmdline {
+			c.cmdline[k] = v
+		}
+	}
+	c.Timings = nil
+	if r.Timings != nil {
+		c.Timings = map[string]time.Duration{}
+		for k, v := range r.Timings {
+			c.Timings[k] = v
+		}
+	}
+	c.DetectionLog = nil
+	if r.DetectionLog != nil {
+		c.DetectionLog = map[string]string{}
+		for k, v := range r.DetectionLog {
+			c.DetectionLog[k] = v
+		}
+	}
+	return c
+}
+
+func (p PartitionState) clone() PartitionState {
+	p.OtherMountPoints = cloneStrings(p.OtherMountPoints)
+	p.FSFeatures = cloneStrings(p.FSFeatures)
+	p.DeviceChain = cloneStrings(p.DeviceChain)
+	return p
+}
+
+// cloneStrings copies a slice, keeping nil ones nil
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
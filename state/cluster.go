@@ -0,0 +1,69 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ClusterK3s  = "k3s"
+	ClusterK0s  = "k0s"
+	ClusterNone = "none"
+
+	ClusterRoleServer  = "server"
+	ClusterRoleAgent   = "agent"
+	ClusterRoleUnknown = "unknown"
+)
+
+// ClusterState is the Kubernetes distribution the node runs and whether it's a server or an agent of the cluster
+type ClusterState struct {
+	Provider string `yaml:"provider" json:"provider"` // One of k3s, k0s or none
+	Role     string `yaml:"role" json:"role"`         // One of server, agent or unknown
+}
+
+// clusterServices are the services each provider installs, by role
+var clusterServices = []struct{ provider, role, service string }{
+	{ClusterK3s, ClusterRoleServer, "k3s"},
+	{ClusterK3s, ClusterRoleAgent, "k3s-agent"},
+	{ClusterK0s, ClusterRoleServer, "k0scontroller"},
+	{ClusterK0s, ClusterRoleAgent, "k0sworker"},
+}
+
+// clusterConfigDirs are left by the providers even before their service is set up, they don't tell the role
+var clusterConfigDirs = []struct{ provider, path string }{
+	{ClusterK3s, "/etc/rancher/k3s"},
+	{ClusterK0s, "/etc/k0s"},
+}
+
+// detectCluster finds the cluster provider from its running service first, as a node can have the services of
+// both roles installed, then from the service files and last from the config of the provider
+func detectCluster(ctx context.Context, r *Runtime, o *Options) {
+	r.Cluster = ClusterState{Provider: ClusterNone, Role: ClusterRoleUnknown}
+	for _, s := range clusterServices {
+		command := fmt.Sprintf("systemctl is-active %s", s.service)
+		if r.Init == InitOpenRC {
+			command = fmt.Sprintf("rc-service %s status", s.service)
+		}
+		out, err := o.Runner(ctx, command)
+		if err == nil && (r.Init == InitOpenRC || strings.TrimSpace(out) == "active") {
+			r.Cluster = ClusterState{Provider: s.provider, Role: s.role}
+			return
+		}
+	}
+	for _, s := range clusterServices {
+		for _, path := range []string{filepath.Join("/etc/systemd/system", s.service+".service"), filepath.Join("/etc/init.d", s.service)} {
+			if exists(o.FS, path) {
+				r.Cluster = ClusterState{Provider: s.provider, Role: s.role}
+				return
+			}
+		}
+	}
+	for _, c := range clusterConfigDirs {
+		if exists(o.FS, c.path) {
+			r.Cluster.Provider = c.provider
+			return
+		}
+	}
+}
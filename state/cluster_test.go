@@ -0,0 +1,41 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("cluster detection", func() {
+	DescribeTable("finds the cluster the node is part of",
+		func(init string, active string, files map[string]interface{}, expected ClusterState) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			o := DefaultOptions()
+			o.FS = fs
+			o.Runner = func(_ context.Context, command string) (string, error) {
+				if active != "" && strings.Contains(command+" ", " "+active+" ") {
+					return "active\n", nil
+				}
+				return "inactive\n", errors.New("exit status 3")
+			}
+			r := &Runtime{Init: init}
+			detectCluster(context.Background(), r, o)
+			Expect(r.Cluster).To(Equal(expected))
+		},
+		Entry("no cluster", InitSystemd, "", map[string]interface{}{"/etc/hostname": "node"}, ClusterState{Provider: ClusterNone, Role: ClusterRoleUnknown}),
+		Entry("a running k3s agent", InitSystemd, "k3s-agent", map[string]interface{}{
+			"/etc/systemd/system/k3s.service":       "",
+			"/etc/systemd/system/k3s-agent.service": "",
+		}, ClusterState{Provider: ClusterK3s, Role: ClusterRoleAgent}),
+		Entry("a running k0s controller on openrc", InitOpenRC, "k0scontroller", map[string]interface{}{"/etc/k0s/k0s.yaml": ""}, ClusterState{Provider: ClusterK0s, Role: ClusterRoleServer}),
+		Entry("a stopped k3s server", InitSystemd, "", map[string]interface{}{"/etc/systemd/system/k3s.service": ""}, ClusterState{Provider: ClusterK3s, Role: ClusterRoleServer}),
+		Entry("only the k3s config", InitSystemd, "", map[string]interface{}{"/etc/rancher/k3s/config.yaml": ""}, ClusterState{Provider: ClusterK3s, Role: ClusterRoleUnknown}),
+	)
+})
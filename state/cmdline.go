@@ -0,0 +1,104 @@
+package state
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// CmdlineParams returns the kernel parameters the system booted with, by key. Flags like quiet have an empty
+// value, and when a key is given several times the last one wins, like for most of the kernel parameters.
+// The cmdline is only read once, when probing. It's not kept when encoding the runtime, so the params are empty
+// for a decoded one instead of describing the machine it's decoded on, unless it was given a filesystem with WithFS
+// to read them from.
+func (r Runtime) CmdlineParams() map[string]string {
+	params := r.cmdline
+	if params == nil && r.fs != nil {
+		params = readCmdlineParams(r.fs)
+	}
+	c := map[string]string{}
+	for k, v := range params {
+		c[k] = v
+	}
+	return c
+}
+
+// bootloaderParams are added by the bootloader itself, they are never in the configured options
+var bootloaderParams = map[string]bool{"BOOT_IMAGE": true, "initrd": true}
+
+// CmdlineDrift returns the kernel parameters, sorted, that differ between the running cmdline and the options of
+// the default boot entry, so a cmdline change that needs a reboot to take effect can be told. Parameters only on
+// one side count as well, while those using grub variables are skipped as their value can't be known, and so are
+// the ones only running when a variable stands for whole parameters.
+func (r Runtime) CmdlineDrift() ([]string, error) {
+	running := r.CmdlineParams()
+	if len(running) == 0 {
+		return nil, errors.New("could not compare the cmdline, it was not probed")
+	}
+	entry, err := r.defaultBootEntry()
+	if err != nil {
+		return nil, err
+	}
+	configured := parseCmdline(entry.Options)
+
+	drift := []string{}
+	// A variable in place of a whole parameter, like ${extra_cmdline}, can expand to anything
+	expanded := false
+	for k, v := range configured {
+		if strings.HasPrefix(k, "$") {
+			expanded = true
+		}
+		if strings.Contains(k, "$") || strings.Contains(v, "$") {
+			continue
+		}
+		if rv, ok := running[k]; !ok || rv != v {
+			drift = append(drift, k)
+		}
+	}
+	for k := range running {
+		if _, ok := configured[k]; !ok && !bootloaderParams[k] && !expanded {
+			drift = append(drift, k)
+		}
+	}
+	sort.Strings(drift)
+	return drift, nil
+}
+
+// readCmdlineParams parses /proc/cmdline, it's empty if it can't be read
+func readCmdlineParams(fs vfs.FS) map[string]string {
+	cmdline, err := fs.ReadFile("/proc/cmdline")
+	if err != nil {
+		return map[string]string{}
+	}
+	return parseCmdline(string(cmdline))
+}
+
+// parseCmdline splits the cmdline on the spaces outside of double quotes, then each parameter on its first =.
+// Quotes are dropped like the kernel does, so both key="a b" and "key=a b" have the value a b.
+func parseCmdline(cmdline string) map[string]string {
+	params := map[string]string{}
+	var param strings.Builder
+	quoted := false
+	flush := func() {
+		if param.Len() > 0 {
+			key, value, _ := strings.Cut(param.String(), "=")
+			params[key] = value
+			param.Reset()
+		}
+	}
+	for _, c := range cmdline {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case unicode.IsSpace(c) && !quoted:
+			flush()
+		default:
+			param.WriteRune(c)
+		}
+	}
+	flush()
+	return params
+}
@@ -0,0 +1,116 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("CmdlineParams", func() {
+	DescribeTable("parses the kernel parameters",
+		func(cmdline string, expected map[string]string) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": cmdline})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			Expect(Runtime{}.WithFS(fs).CmdlineParams()).To(Equal(expected))
+		},
+		Entry("keys and flags", "BOOT_IMAGE=/cOS/vmlinuz console=tty1 root=LABEL=COS_STATE cos-img/filename=/cOS/active.img panic=5 rd.neednet=0 quiet\n", map[string]string{
+			"BOOT_IMAGE": "/cOS/vmlinuz", "console": "tty1", "root": "LABEL=COS_STATE", "cos-img/filename": "/cOS/active.img",
+			"panic": "5", "rd.neednet": "0", "quiet": "",
+		}),
+		Entry("quoted values", `dyndbg="file drivers/usb/* +p" "kairos.note=a b" nomodeset`, map[string]string{
+			"dyndbg": "file drivers/usb/* +p", "kairos.note": "a b", "nomodeset": "",
+		}),
+		Entry("repeated keys", "console=tty1 console=ttyS0,115200", map[string]string{"console": "ttyS0,115200"}),
+		Entry("an empty value", "rd.cos.disable= ", map[string]string{"rd.cos.disable": ""}),
+	)
+
+	It("is empty without a cmdline", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/hostname": "node"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(Runtime{}.WithFS(fs).CmdlineParams()).To(BeEmpty())
+	})
+
+	It("is empty for a decoded runtime instead of reading the local cmdline", func() {
+		dat, err := Runtime{BootState: Active}.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		r, err := Decode(dat)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.CmdlineParams()).To(BeEmpty())
+	})
+
+	It("uses the cmdline read while probing", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE kairos.debug"})
+		Expect(err).ToNot(HaveOccurred())
+		noTools := WithCommandRunner(func(_ context.Context, _ string) (string, error) {
+			return "", errors.New("exit status 1")
+		})
+		r, _ := NewRuntimeWithOptions(WithFS(fs), noTools, WithHost(nil, SystemInfo{}, nil))
+		cleanup()
+
+		params := r.CmdlineParams()
+		Expect(params).To(HaveKeyWithValue("root", "LABEL=COS_ACTIVE"))
+		Expect(params).To(HaveKey("kairos.debug"))
+		params["root"] = "changed"
+		Expect(r.CmdlineParams()).To(HaveKeyWithValue("root", "LABEL=COS_ACTIVE"))
+	})
+})
+
+var _ = Describe("CmdlineDrift", func() {
+	It("compares the running cmdline with the default systemd-boot entry", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/cmdline":                    "initrd=\\initrd console=ttyS0 root=LABEL=COS_ACTIVE panic=5 quiet",
+			"/efi/loader/loader.conf":          "timeout 5\ndefault passive*\n",
+			"/efi/loader/entries/active.conf":  "title Kairos\nlinux /vmlinuz\noptions console=ttyS0 root=LABEL=COS_ACTIVE panic=5 quiet\n",
+			"/efi/loader/entries/passive.conf": "title Kairos (fallback)\nlinux /vmlinuz\noptions console=tty1 root=LABEL=COS_PASSIVE panic=5 rd.debug\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(Runtime{}.WithFS(fs).CmdlineDrift()).To(Equal([]string{"console", "quiet", "rd.debug", "root"}))
+	})
+
+	It("uses the saved entry of grub and skips its variables", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/cmdline":                    "BOOT_IMAGE=/cOS/vmlinuz console=tty1 root=LABEL=COS_ACTIVE kairos.debug",
+			"/run/initramfs/cos-state/grubenv": "# GRUB Environment Block\nsaved_entry=Kairos recovery\n",
+			"/run/initramfs/cos-state/grub2/grub.cfg": `menuentry "Kairos" --id cos {
+  linux /cOS/vmlinuz console=tty1 root=LABEL=COS_ACTIVE ${extra_cmdline}
+}
+menuentry "Kairos recovery" --id recovery {
+  linux /cOS/vmlinuz console=tty1 root=LABEL=COS_RECOVERY img=${img} ${extra_cmdline}
+}
+`,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		r := Runtime{State: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"}}.WithFS(fs)
+		Expect(r.CmdlineDrift()).To(Equal([]string{"root"}))
+	})
+
+	It("fails when the cmdline wasn't probed", func() {
+		dat, err := Runtime{BootState: Active}.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		r, err := Decode(dat)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = r.CmdlineDrift()
+		Expect(err).To(MatchError(ContainSubstring("not probed")))
+	})
+
+	It("fails without a bootloader config", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "quiet"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		_, err = Runtime{}.WithFS(fs).CmdlineDrift()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,39 @@
+package state
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// containerCgroups are the cgroup path fragments container runtimes put their processes in
+var containerCgroups = []string{"docker", "kubepods", "containerd", "libpod", "lxc"}
+
+// DetectContainerWithVFS detects if we are running inside a container using a vfs so it can be used for tests as well
+func DetectContainerWithVFS(fs vfs.FS) bool {
+	if exists(fs, "/.dockerenv") || exists(fs, "/run/.containerenv") {
+		return true
+	}
+	// systemd and most runtimes set the container env var for the init process
+	if environ, err := fs.ReadFile("/proc/1/environ"); err == nil {
+		for _, env := range bytes.Split(environ, []byte{0}) {
+			if bytes.HasPrefix(env, []byte("container=")) {
+				return true
+			}
+		}
+	}
+	if cgroup, err := fs.ReadFile("/proc/1/cgroup"); err == nil {
+		for _, c := range containerCgroups {
+			if strings.Contains(string(cgroup), c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InContainer returns whether the runtime is inside a container, in which case the hardware info is not probed
+func (r Runtime) InContainer() bool {
+	return DetectContainerWithVFS(r.filesystem())
+}
@@ -0,0 +1,40 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectContainerWithVFS", func() {
+	detect := func(files map[string]interface{}) bool {
+		fs, cleanup, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		return DetectContainerWithVFS(fs)
+	}
+
+	It("detects docker", func() {
+		Expect(detect(map[string]interface{}{"/.dockerenv": ""})).To(BeTrue())
+	})
+
+	It("detects podman", func() {
+		Expect(detect(map[string]interface{}{"/run/.containerenv": ""})).To(BeTrue())
+	})
+
+	It("detects the container env var", func() {
+		Expect(detect(map[string]interface{}{"/proc/1/environ": "HOME=/\x00container=podman\x00TERM=xterm\x00"})).To(BeTrue())
+	})
+
+	It("detects kubernetes cgroups", func() {
+		Expect(detect(map[string]interface{}{"/proc/1/cgroup": "0::/kubepods/besteffort/pod1234/abcd\n"})).To(BeTrue())
+	})
+
+	It("doesn't detect a container on the host", func() {
+		Expect(detect(map[string]interface{}{
+			"/proc/1/environ": "HOME=/\x00TERM=linux\x00",
+			"/proc/1/cgroup":  "0::/init.scope\n",
+		})).To(BeFalse())
+	})
+})
@@ -0,0 +1,111 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	UnlockTPM        = "tpm"
+	UnlockPassphrase = "passphrase"
+	UnlockNone       = "none"
+)
+
+const (
+	// luks2JSONOffset is where the JSON metadata area starts, right after the binary header
+	luks2JSONOffset = 4096
+	// luks2MaxHeader is the biggest header size we are willing to read, the default one is 16KiB
+	luks2MaxHeader = 4 * 1024 * 1024
+)
+
+// DetectEncryptionWithVFS reports whether the device is a dm-crypt mapping and how it was unlocked, using a vfs so
+// it can be used for tests as well. The device has to be the resolved one (/dev/dm-N), not a /dev/mapper link.
+// Volumes unlocked with a keyfile are reported as passphrase, as the secret didn't come from the TPM.
+func DetectEncryptionWithVFS(fs vfs.FS, device string) (encrypted bool, unlockMethod string) {
+	dm := filepath.Join("/sys/block", filepath.Base(device), "dm")
+	uuid, err := fs.ReadFile(filepath.Join(dm, "uuid"))
+	if err != nil || !strings.HasPrefix(string(uuid), "CRYPT-") {
+		return false, UnlockNone
+	}
+
+	name, err := fs.ReadFile(filepath.Join(dm, "name"))
+	if err == nil && crypttabUsesTPM(fs, strings.TrimSpace(string(name))) {
+		return true, UnlockTPM
+	}
+
+	// The volume might have been unlocked by systemd-cryptenroll tokens, which live in the LUKS2 header
+	slaves, err := fs.ReadDir(filepath.Join("/sys/block", filepath.Base(device), "slaves"))
+	if err == nil {
+		for _, slave := range slaves {
+			if luksHasTPMToken(fs, filepath.Join("/dev", slave.Name())) {
+				return true, UnlockTPM
+			}
+		}
+	}
+	return true, UnlockPassphrase
+}
+
+// crypttabUsesTPM checks if the crypttab entry for the given mapping is set to unlock it with a TPM2 device
+func crypttabUsesTPM(fs vfs.FS, name string) bool {
+	dat, err := fs.ReadFile("/etc/crypttab")
+	if err != nil {
+		return false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") || fields[0] != name {
+			continue
+		}
+		for _, opt := range strings.Split(fields[3], ",") {
+			if strings.HasPrefix(opt, "tpm2-device=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// luksHasTPMToken reads the LUKS2 JSON metadata of the device looking for a systemd-tpm2 token
+func luksHasTPMToken(fs vfs.FS, device string) bool {
+	f, err := fs.Open(device)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, luks2MaxHeader)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	header = header[:n]
+	if len(header) <= luks2JSONOffset || !bytes.HasPrefix(header, []byte("LUKS\xba\xbe")) {
+		return false
+	}
+	// The JSON area is padded with zeros up to its size
+	area := header[luks2JSONOffset:]
+	if end := bytes.IndexByte(area, 0); end != -1 {
+		area = area[:end]
+	}
+	metadata := struct {
+		Tokens map[string]struct {
+			Type string `json:"type"`
+		} `json:"tokens"`
+	}{}
+	if err := json.Unmarshal(area, &metadata); err != nil {
+		return false
+	}
+	for _, t := range metadata.Tokens {
+		if t.Type == "systemd-tpm2" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package state_test
+
+import (
+	"bytes"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func luksHeader(metadata string) []byte {
+	header := make([]byte, 4096)
+	copy(header, "LUKS\xba\xbe")
+	header = append(header, []byte(metadata)...)
+	return append(header, bytes.Repeat([]byte{0}, 1024)...)
+}
+
+var _ = Describe("DetectEncryptionWithVFS", func() {
+	var files map[string]interface{}
+
+	BeforeEach(func() {
+		files = map[string]interface{}{
+			"/sys/block/dm-0/dm/uuid":     "CRYPT-LUKS2-8e1cd5ac6e8a4c2e9b6f3d0c1a2b3c4d-persistent\n",
+			"/sys/block/dm-0/dm/name":     "persistent\n",
+			"/sys/block/dm-0/slaves/sda5": "",
+			"/sys/block/dm-1/dm/uuid":     "LVM-abcdef\n",
+			"/sys/block/dm-1/dm/name":     "vg-oem\n",
+			"/dev/sda5":                   luksHeader(`{"keyslots":{"0":{"type":"luks2"}},"tokens":{}}`),
+		}
+	})
+
+	detect := func(device string) (bool, string) {
+		fs, cleanup, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		return DetectEncryptionWithVFS(fs, device)
+	}
+
+	It("reports plain partitions as not encrypted", func() {
+		encrypted, method := detect("/dev/sda2")
+		Expect(encrypted).To(BeFalse())
+		Expect(method).To(Equal(UnlockNone))
+	})
+
+	It("reports non crypt device mapper devices as not encrypted", func() {
+		encrypted, method := detect("/dev/dm-1")
+		Expect(encrypted).To(BeFalse())
+		Expect(method).To(Equal(UnlockNone))
+	})
+
+	It("defaults to passphrase for encrypted devices", func() {
+		encrypted, method := detect("/dev/dm-0")
+		Expect(encrypted).To(BeTrue())
+		Expect(method).To(Equal(UnlockPassphrase))
+	})
+
+	It("detects tpm unlock from crypttab", func() {
+		files["/etc/crypttab"] = "# comment\npersistent /dev/sda5 none tpm2-device=auto,discard\n"
+		encrypted, method := detect("/dev/dm-0")
+		Expect(encrypted).To(BeTrue())
+		Expect(method).To(Equal(UnlockTPM))
+	})
+
+	It("ignores crypttab entries for other volumes", func() {
+		files["/etc/crypttab"] = "other /dev/sda6 none tpm2-device=auto\npersistent /dev/sda5 none luks\n"
+		_, method := detect("/dev/dm-0")
+		Expect(method).To(Equal(UnlockPassphrase))
+	})
+
+	It("detects tpm unlock from systemd-cryptenroll tokens", func() {
+		files["/dev/sda5"] = luksHeader(`{"keyslots":{"0":{"type":"luks2"}},"tokens":{"0":{"type":"systemd-tpm2","keyslots":["0"]}}}`)
+		encrypted, method := detect("/dev/dm-0")
+		Expect(encrypted).To(BeTrue())
+		Expect(method).To(Equal(UnlockTPM))
+	})
+})
@@ -0,0 +1,37 @@
+package state_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("NewRuntimeWithDeadline", func() {
+	It("returns what was gathered when a stage hangs", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/cmdline":   "root=LABEL=COS_ACTIVE",
+			"/etc/os-release": "KAIROS_FLAVOR=opensuse\nKAIROS_VERSION=v2.4.0\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		// A hung tool that doesn't even honour the context, it's only released once the test is over so returning at
+		// all shows the probe didn't wait for it
+		release := make(chan struct{})
+		defer close(release)
+		hung := func(_ context.Context, _ string) (string, error) {
+			<-release
+			return "", nil
+		}
+		r, err := NewRuntimeWithDeadline(100*time.Millisecond, WithFS(fs), WithCommandRunner(hung), WithHost(nil, SystemInfo{}, nil))
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(r.BootState).To(Equal(Active))
+		Expect(r.Kairos.Version).To(Equal("v2.4.0"))
+		Expect(r.Cluster).To(BeZero())
+		Expect(r.Persistent).To(BeZero())
+	})
+})
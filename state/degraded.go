@@ -0,0 +1,40 @@
+package state
+
+import "github.com/twpayne/go-vfs/v4"
+
+// degradedTargets are the systemd targets a node ends up in when booting fails
+var degradedTargets = []string{"emergency.target", "rescue.target"}
+
+// DetectDegradedBootWithVFS returns whether the system booted into the emergency or rescue target, either because
+// it was asked to in the cmdline or because systemd fell back to it. It uses a vfs so it can be used for tests as well.
+func DetectDegradedBootWithVFS(fs vfs.FS) bool {
+	return degradedBoot(fs, readCmdlineParams(fs))
+}
+
+// degradedBoot is DetectDegradedBootWithVFS with the kernel parameters already read
+func degradedBoot(fs vfs.FS, params map[string]string) bool {
+	for _, flag := range []string{"emergency", "rescue", "single", "-b"} {
+		if _, ok := params[flag]; ok {
+			return true
+		}
+	}
+	if isDegradedTarget(params["systemd.unit"]) {
+		return true
+	}
+	// systemd keeps a link for every unit it started in this boot, pointing to its invocation id and not to a file
+	for _, t := range degradedTargets {
+		if _, err := fs.Lstat("/run/systemd/units/invocation:" + t); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isDegradedTarget(unit string) bool {
+	for _, t := range degradedTargets {
+		if unit == t {
+			return true
+		}
+	}
+	return false
+}
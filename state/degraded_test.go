@@ -0,0 +1,30 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectDegradedBootWithVFS", func() {
+	DescribeTable("detects degraded boots",
+		func(files map[string]interface{}, expected bool) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectDegradedBootWithVFS(fs)).To(Equal(expected))
+		},
+		Entry("a healthy boot", map[string]interface{}{
+			"/proc/cmdline": "root=LABEL=COS_ACTIVE",
+			"/run/systemd/units/invocation:multi-user.target": &vfst.Symlink{Target: "0123456789abcdef"},
+		}, false),
+		Entry("emergency target in the cmdline", map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE systemd.unit=emergency.target"}, true),
+		Entry("rescue in the cmdline", map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE rescue"}, true),
+		Entry("another target in the cmdline", map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE systemd.unit=multi-user.target"}, false),
+		Entry("systemd fell back to emergency", map[string]interface{}{
+			"/proc/cmdline": "root=LABEL=COS_ACTIVE",
+			"/run/systemd/units/invocation:emergency.target": &vfst.Symlink{Target: "0123456789abcdef"},
+		}, true),
+	)
+})
@@ -0,0 +1,38 @@
+package state
+
+import "sync"
+
+// PartitionDetector detects a partition the SDK doesn't know about, using the runner for any external tool it needs
+type PartitionDetector func(runner CommandRunner) PartitionState
+
+var (
+	detectorsMu sync.RWMutex
+	detectors   = map[string]PartitionDetector{}
+)
+
+// RegisterPartitionDetector plugs in detection for a custom partition label. The result of the detector is stored
+// under the label in Runtime.Extra every time the runtime is probed.
+// Registration is process-global, so it should happen at init time, before any runtime is probed.
+// Registering the same label twice replaces the previous detector.
+func RegisterPartitionDetector(label string, fn func(runner CommandRunner) PartitionState) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	detectors[label] = fn
+}
+
+// detectExtraPartitions runs all the registered detectors
+func detectExtraPartitions(r *Runtime, o *Options) {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	if len(detectors) == 0 {
+		return
+	}
+	if r.Extra == nil {
+		r.Extra = map[string]PartitionState{}
+	}
+	for label, fn := range detectors {
+		stop := o.track(r, "detector/"+label)
+		r.Extra[label] = fn(o.Runner)
+		stop()
+	}
+}
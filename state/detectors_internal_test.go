@@ -0,0 +1,57 @@
+package state
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("RegisterPartitionDetector", func() {
+	var runtime func() Runtime
+	var cleanup func()
+
+	BeforeEach(func() {
+		fs, c, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE"})
+		Expect(err).ToNot(HaveOccurred())
+		cleanup = c
+		runner := func(_ context.Context, command string) (string, error) {
+			if command == "blkid -L DATA" {
+				return "/dev/sdb1", nil
+			}
+			return "", errors.New("exit status 1")
+		}
+		runtime = func() Runtime {
+			r, err := NewRuntimeWithOptions(WithFS(fs), WithCommandRunner(runner), WithHost(nil, SystemInfo{}, nil))
+			Expect(err).ToNot(HaveOccurred())
+			return r
+		}
+	})
+
+	AfterEach(func() {
+		cleanup()
+		detectorsMu.Lock()
+		defer detectorsMu.Unlock()
+		delete(detectors, "DATA")
+	})
+
+	It("stores the result of the detector in Extra under its label", func() {
+		RegisterPartitionDetector("DATA", func(runner CommandRunner) PartitionState {
+			out, err := runner(context.Background(), "blkid -L DATA")
+			return PartitionState{Found: err == nil, Name: out}
+		})
+		Expect(runtime().Extra).To(HaveKeyWithValue("DATA", PartitionState{Found: true, Name: "/dev/sdb1"}))
+	})
+
+	It("replaces the detector registered under the same label", func() {
+		RegisterPartitionDetector("DATA", func(_ CommandRunner) PartitionState {
+			return PartitionState{Found: true, Name: "/dev/sdb1"}
+		})
+		RegisterPartitionDetector("DATA", func(_ CommandRunner) PartitionState {
+			return PartitionState{Found: true, Name: "/dev/sdc1"}
+		})
+		Expect(runtime().Extra).To(HaveKeyWithValue("DATA", PartitionState{Found: true, Name: "/dev/sdc1"}))
+	})
+})
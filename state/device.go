@@ -0,0 +1,72 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// maxSymlinks is the number of links we are willing to follow before giving up, same as the kernel limit
+const maxSymlinks = 40
+
+// CanonicalDeviceWithVFS resolves all the symlinks in a device path like /dev/disk/by-label/COS_OEM or
+// /dev/mapper/vg-lv into the real device node, using a vfs so it can be used for tests as well.
+// This is filepath.EvalSymlinks but going through the vfs instead of the real filesystem.
+func CanonicalDeviceWithVFS(fs vfs.FS, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("device path %s is not absolute", path)
+	}
+	links := 0
+	resolved := "/"
+	pending := strings.Split(path, "/")
+	for len(pending) > 0 {
+		component := pending[0]
+		pending = pending[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, component)
+		info, err := fs.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links resolving %s", path)
+		}
+		target, err := fs.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// canonicalizeDevice makes sure the partition name points to the real device, keeping the original name around
+// if it was a link. Failing to resolve the device leaves the partition untouched.
+func canonicalizeDevice(fs vfs.FS, p *PartitionState) {
+	if !p.Found || p.Name == "" {
+		return
+	}
+	resolved, err := CanonicalDeviceWithVFS(fs, p.Name)
+	if err != nil || resolved == p.Name {
+		return
+	}
+	p.DeviceLink = p.Name
+	p.Name = resolved
+}
@@ -0,0 +1,60 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("CanonicalDeviceWithVFS", func() {
+	var fs vfs.FS
+	var cleanup func()
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{
+			"/dev/sda1":                         "",
+			"/dev/dm-0":                         "",
+			"/dev/disk/by-label/COS_OEM":        &vfst.Symlink{Target: "../../sda1"},
+			"/dev/mapper/vg-persistent":         &vfst.Symlink{Target: "../dm-0"},
+			"/dev/disk/by-label/COS_PERSISTENT": &vfst.Symlink{Target: "../../mapper/vg-persistent"},
+			"/dev/disk/by-id/loop-a":            &vfst.Symlink{Target: "loop-b"},
+			"/dev/disk/by-id/loop-b":            &vfst.Symlink{Target: "loop-a"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("returns real devices untouched", func() {
+		dev, err := CanonicalDeviceWithVFS(fs, "/dev/sda1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dev).To(Equal("/dev/sda1"))
+	})
+
+	It("resolves a single symlink", func() {
+		dev, err := CanonicalDeviceWithVFS(fs, "/dev/disk/by-label/COS_OEM")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dev).To(Equal("/dev/sda1"))
+	})
+
+	It("resolves layered symlinks", func() {
+		dev, err := CanonicalDeviceWithVFS(fs, "/dev/disk/by-label/COS_PERSISTENT")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dev).To(Equal("/dev/dm-0"))
+	})
+
+	It("fails on symlink loops", func() {
+		_, err := CanonicalDeviceWithVFS(fs, "/dev/disk/by-id/loop-a")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails on missing devices", func() {
+		_, err := CanonicalDeviceWithVFS(fs, "/dev/disk/by-label/COS_STATE")
+		Expect(err).To(HaveOccurred())
+	})
+})
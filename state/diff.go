@@ -0,0 +1,39 @@
+package state
+
+import (
+	"context"
+	"sort"
+)
+
+// Diff returns the paths of the values that differ between the runtimes, as Flatten names them, sorted.
+// Values only present in one of them are reported as well.
+func (r Runtime) Diff(other Runtime) []string {
+	a, b := r.Flatten(), other.Flatten()
+	changed := []string{}
+	for k, v := range a {
+		if ov, ok := b[k]; !ok || ov != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// NewRuntimeDelta probes the system like NewRuntimeWithOptions and returns it along with the paths that changed
+// since the baseline, so only those need to be sent around
+func NewRuntimeDelta(baseline Runtime, opts ...Option) (Runtime, []string, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return Runtime{}, nil, err
+	}
+	r, err := probe(context.Background(), o, true)
+	if err != nil {
+		return r, nil, err
+	}
+	return r, baseline.Diff(r), nil
+}
@@ -0,0 +1,37 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("NewRuntimeDelta", func() {
+	It("only returns the paths that changed since the baseline", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/cmdline":   "root=LABEL=COS_ACTIVE",
+			"/etc/os-release": "KAIROS_FLAVOR=alpine\nKAIROS_VERSION=v2.4.0\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		opts := []Option{WithFS(fs), WithHost(nil, SystemInfo{}, nil), WithCommandRunner(func(_ context.Context, _ string) (string, error) {
+			return "", errors.New("exit status 1")
+		})}
+
+		baseline, err := NewRuntimeWithOptions(opts...)
+		Expect(err).ToNot(HaveOccurred())
+		_, changed, err := NewRuntimeDelta(baseline, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeEmpty())
+
+		Expect(fs.WriteFile("/etc/os-release", []byte("KAIROS_FLAVOR=alpine\nKAIROS_VERSION=v2.5.0\n"), 0o644)).To(Succeed())
+		r, changed, err := NewRuntimeDelta(baseline, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Kairos.Version).To(Equal("v2.5.0"))
+		Expect(changed).To(Equal([]string{"kairos.version"}))
+	})
+})
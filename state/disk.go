@@ -0,0 +1,302 @@
+package state
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jaypipes/ghw/pkg/block"
+	"github.com/jaypipes/ghw/pkg/util"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// DiskState is a disk found while probing, partitions or not
+type DiskState struct {
+	Name      string `yaml:"name" json:"name"`
+	SizeBytes uint64 `yaml:"size_bytes" json:"size_bytes"`
+	Model     string `yaml:"model,omitempty" json:"model,omitempty"`
+	Removable bool   `yaml:"removable" json:"removable"`
+	Hotplug   bool   `yaml:"hotplug" json:"hotplug"` // Set for disks on a hotpluggable bus, like USB sticks
+	// PartitionTable is one of gpt, mbr or unknown
+	PartitionTable string `yaml:"partition_table" json:"partition_table"`
+	// PhysicalSectorSize and LogicalSectorSize are in bytes, like 4096 and 512 for 512e disks, or zero if unknown
+	PhysicalSectorSize uint64 `yaml:"physical_sector_size" json:"physical_sector_size"`
+	LogicalSectorSize  uint64 `yaml:"logical_sector_size" json:"logical_sector_size"`
+	// Aligned is unset when any of the partitions of the disk is misaligned, see PartitionAlignmentWithVFS
+	Aligned bool `yaml:"aligned" json:"aligned"`
+	// AllocatedBytes is the space the disk really takes on its backing store, which is less than SizeBytes when
+	// it's thin-provisioned. Allocated is only set when it could be told, for now only for loop devices.
+	Allocated      bool   `yaml:"allocated" json:"allocated"`
+	AllocatedBytes uint64 `yaml:"allocated_bytes,omitempty" json:"allocated_bytes,omitempty"`
+	// WWN and Serial identify the drive for asset tracking, they are usually empty on VMs
+	WWN    string `yaml:"wwn,omitempty" json:"wwn,omitempty"`
+	Serial string `yaml:"serial,omitempty" json:"serial,omitempty"`
+}
+
+const (
+	PartitionTableGPT     = "gpt"
+	PartitionTableMBR     = "mbr"
+	PartitionTableUnknown = "unknown"
+)
+
+// PartitionTableWithVFS detects the partition table of a disk from its first sectors using a vfs so it can be used
+// for tests as well. The GPT header is looked up after both 512 and 4096 bytes sectors, and checked before the
+// MBR signature as GPT disks carry a protective MBR.
+func PartitionTableWithVFS(fs vfs.FS, device string) string {
+	f, err := fs.Open(device)
+	if err != nil {
+		return PartitionTableUnknown
+	}
+	defer f.Close()
+	head := make([]byte, 4096+8)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	for _, sector := range []int{512, 4096} {
+		if len(head) >= sector+8 && string(head[sector:sector+8]) == "EFI PART" {
+			return PartitionTableGPT
+		}
+	}
+	if len(head) >= 512 && head[510] == 0x55 && head[511] == 0xAA {
+		return PartitionTableMBR
+	}
+	return PartitionTableUnknown
+}
+
+// diskState fills the DiskState of a disk found by ghw, double-checking the removable flag in sysfs as not every
+// driver reports it the same way
+func diskState(fs vfs.FS, d *block.Disk) DiskState {
+	disk := DiskState{
+		Name:           fmt.Sprintf("/dev/%s", d.Name),
+		SizeBytes:      d.SizeBytes,
+		Model:          d.Model,
+		Removable:      d.IsRemovable,
+		Hotplug:        strings.Contains(d.BusPath, "usb"),
+		PartitionTable: PartitionTableWithVFS(fs, fmt.Sprintf("/dev/%s", d.Name)),
+		// ghw only knows about the physical one, the logical one is read from sysfs below
+		PhysicalSectorSize: d.PhysicalBlockSizeBytes,
+		Aligned:            true,
+		WWN:                knownValue(d.WWN),
+		Serial:             knownValue(d.SerialNumber),
+	}
+	for _, p := range d.Partitions {
+		if _, aligned := PartitionAlignmentWithVFS(fs, p.Name); !aligned {
+			disk.Aligned = false
+		}
+	}
+	if path, err := sysfsBlockPath(fs, d.Name); err == nil {
+		if size, err := readSysfsUint(fs, filepath.Join(path, "queue", "physical_block_size")); err == nil {
+			disk.PhysicalSectorSize = size
+		}
+		if size, err := readSysfsUint(fs, filepath.Join(path, "queue", "logical_block_size")); err == nil {
+			disk.LogicalSectorSize = size
+		}
+		if removable, err := readSysfsUint(fs, filepath.Join(path, "removable")); err == nil && removable == 1 {
+			disk.Removable = true
+		}
+		if strings.Contains(path, "/usb") {
+			disk.Hotplug = true
+		}
+		disk.AllocatedBytes, disk.Allocated = allocatedBytes(fs, path)
+		// ghw gets them from the udev database, which is not always around, sysfs has them for most drives
+		if disk.WWN == "" {
+			disk.WWN = readSysfsString(fs, filepath.Join(path, "device", "wwid"))
+			if id, ok := strings.CutPrefix(disk.WWN, "naa."); ok {
+				disk.WWN = "0x" + id
+			}
+		}
+		for _, f := range []string{"device/serial", "serial"} {
+			if disk.Serial == "" {
+				disk.Serial = readSysfsString(fs, filepath.Join(path, f))
+			}
+		}
+	}
+	return disk
+}
+
+// knownValue drops the placeholder ghw uses for the values it couldn't find
+func knownValue(v string) string {
+	if v == util.UNKNOWN {
+		return ""
+	}
+	return v
+}
+
+// allocatedBytes returns the space taken by the backing file of a loop device, given its sysfs dir. Guests can't
+// see how much of their virtual disks the hypervisor allocated, so this is only known for loop devices.
+func allocatedBytes(fs vfs.FS, sysfsPath string) (uint64, bool) {
+	dat, err := fs.ReadFile(filepath.Join(sysfsPath, "loop", "backing_file"))
+	if err != nil {
+		return 0, false
+	}
+	info, err := fs.Stat(strings.TrimSpace(string(dat)))
+	if err != nil {
+		return 0, false
+	}
+	return allocatedFileBytes(info)
+}
+
+// PartitionAlignmentWithVFS returns where a partition starts on its disk, in bytes, and whether that is a multiple
+// of the optimal io size of the disk, or of its physical sector size for disks that don't report one. It returns
+// 0 and aligned when it can't be told, like for LVM volumes.
+func PartitionAlignmentWithVFS(fs vfs.FS, device string) (offset uint64, aligned bool) {
+	start, _, diskPath, err := partitionExtent(fs, device)
+	if err != nil {
+		return 0, true
+	}
+	grain, err := readSysfsUint(fs, filepath.Join(diskPath, "queue", "optimal_io_size"))
+	if err != nil || grain == 0 {
+		grain, err = readSysfsUint(fs, filepath.Join(diskPath, "queue", "physical_block_size"))
+	}
+	if err != nil || grain == 0 {
+		return start, true
+	}
+	return start, start%grain == 0
+}
+
+// RemovableDisks returns the disks that are removable or hotpluggable, like the USB stick the installer booted from
+func (r Runtime) RemovableDisks() []DiskState {
+	removable := []DiskState{}
+	for _, d := range r.Disks {
+		if d.Removable || d.Hotplug {
+			removable = append(removable, d)
+		}
+	}
+	return removable
+}
+
+// partitionByLabel finds one of the detected partitions by its filesystem label
+func (r Runtime) partitionByLabel(label string) (PartitionState, error) {
+	parts := []PartitionState{r.Persistent, r.Recovery, r.OEM, r.State}
+	for _, p := range r.Extra {
+		parts = append(parts, p)
+	}
+	for _, p := range parts {
+		if p.Found && p.FilesystemLabel == label {
+			return p, nil
+		}
+	}
+	return PartitionState{}, fmt.Errorf("no partition found with label %s", label)
+}
+
+// UnallocatedBytesAfter returns how much free space follows the partition with the given label on its disk,
+// up to the next partition or the end of the disk. That's how much the partition can be grown.
+func (r Runtime) UnallocatedBytesAfter(label string) (uint64, error) {
+	part, err := r.partitionByLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	fs := r.filesystem()
+	_, end, diskPath, err := partitionExtent(fs, part.Name)
+	if err != nil {
+		return 0, fmt.Errorf("could not find the disk of %s: %w", part.Name, err)
+	}
+	diskSectors, err := readSysfsUint(fs, filepath.Join(diskPath, "size"))
+	if err != nil {
+		return 0, fmt.Errorf("could not read the size of the disk of %s: %w", part.Name, err)
+	}
+
+	limit := diskSectors * sysfsSectorSize
+	entries, err := fs.ReadDir(diskPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		start, _, _, err := partitionExtent(fs, filepath.Join("/dev", e.Name()))
+		if err != nil {
+			// Not a partition, sysfs disk dirs also hold attributes and other subdirs
+			continue
+		}
+		if start >= end && start < limit {
+			limit = start
+		}
+	}
+	if limit < end {
+		return 0, nil
+	}
+	return limit - end, nil
+}
+
+// PersistentIsDedicatedDisk returns whether persistent lives on a disk of its own, not shared with state.
+// Persistent on LVM or RAID counts as dedicated only if none of the disks below it hold state either.
+func (r Runtime) PersistentIsDedicatedDisk() (bool, error) {
+	fs := r.filesystem()
+	disks := map[string][]string{}
+	for _, p := range []struct {
+		name string
+		part PartitionState
+	}{{"persistent", r.Persistent}, {"state", r.State}} {
+		if !p.part.Found {
+			return false, fmt.Errorf("%s partition not found", p.name)
+		}
+		disks[p.name] = parentDisks(fs, p.part.Name)
+		if len(disks[p.name]) == 0 {
+			return false, fmt.Errorf("could not find the disk of %s", p.part.Name)
+		}
+	}
+	for _, p := range disks["persistent"] {
+		for _, s := range disks["state"] {
+			if p == s {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// InstallDevice returns the disk Kairos was installed to, which is the one holding the state partition. That's the
+// disk to target when reinstalling, not the boot media.
+func (r Runtime) InstallDevice() (string, error) {
+	if !r.State.Found {
+		return "", fmt.Errorf("state partition not found")
+	}
+	disks := parentDisks(r.filesystem(), r.State.Name)
+	switch len(disks) {
+	case 0:
+		return "", fmt.Errorf("could not find the disk of %s", r.State.Name)
+	case 1:
+		return disks[0], nil
+	default:
+		return "", fmt.Errorf("%s spans several disks: %s", r.State.Name, strings.Join(disks, ", "))
+	}
+}
+
+// SharedDevices reports the devices backing more than one of the found partitions, with the labels of the
+// partitions on each. Two labels ending up on the same device is a provisioning mistake, so this is usually empty.
+func (r Runtime) SharedDevices() map[string][]string {
+	labels := map[string][]string{}
+	add := func(label string, p PartitionState) {
+		if !p.Found || p.Name == "" {
+			return
+		}
+		labels[p.Name] = append(labels[p.Name], label)
+	}
+	for _, p := range []PartitionState{r.Persistent, r.Recovery, r.OEM, r.State} {
+		add(p.FilesystemLabel, p)
+	}
+	for label, p := range r.Extra {
+		add(label, p)
+	}
+
+	shared := map[string][]string{}
+	for device, l := range labels {
+		if len(l) > 1 {
+			sort.Strings(l)
+			shared[device] = l
+		}
+	}
+	return shared
+}
+
+// LabelConflicts reports the known labels found on more than one device, with those devices. A leftover disk
+// carrying COS_STATE can shadow the real one, and only one of them ends up in the runtime.
+func (r Runtime) LabelConflicts() map[string][]string {
+	conflicts := map[string][]string{}
+	for label, devices := range r.LabelDevices {
+		if len(devices) > 1 {
+			conflicts[label] = devices
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"os"
+
+	"github.com/jaypipes/ghw/pkg/block"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("disk state", func() {
+	It("measures the space allocated to loop devices", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/sys/devices/virtual/block/loop0/loop/backing_file": "/var/lib/disk.img\n",
+			"/sys/class/block/loop0":                             &vfst.Symlink{Target: "../../devices/virtual/block/loop0"},
+			"/var/lib/disk.img":                                  "data",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		path, err := fs.RawPath("/var/lib/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Truncate(path, 1024*1024*1024)).To(Succeed())
+
+		disk := diskState(fs, &block.Disk{Name: "loop0", SizeBytes: 1024 * 1024 * 1024})
+		Expect(disk.Allocated).To(BeTrue())
+		Expect(disk.AllocatedBytes).To(BeNumerically(">", 0))
+		Expect(disk.AllocatedBytes).To(BeNumerically("<", disk.SizeBytes))
+
+		Expect(diskState(fs, &block.Disk{Name: "sda"}).Allocated).To(BeFalse())
+	})
+
+	It("reads the wwn and serial of disks", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/sys/devices/pci0000:00/ata1/host0/block/sda/device/wwid":   "naa.5000c500a1b2c3d4\n",
+			"/sys/devices/pci0000:00/ata1/host0/block/sda/device/serial": "WD-WX12345678\n",
+			"/sys/devices/pci0000:00/virtio2/block/vda/serial":           "disk-1\n",
+			"/sys/class/block/sda": &vfst.Symlink{Target: "../../devices/pci0000:00/ata1/host0/block/sda"},
+			"/sys/class/block/vda": &vfst.Symlink{Target: "../../devices/pci0000:00/virtio2/block/vda"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		disk := diskState(fs, &block.Disk{Name: "sda", WWN: "unknown", SerialNumber: "unknown"})
+		Expect(disk.WWN).To(Equal("0x5000c500a1b2c3d4"))
+		Expect(disk.Serial).To(Equal("WD-WX12345678"))
+
+		disk = diskState(fs, &block.Disk{Name: "sda", WWN: "0x5000c500deadbeef", SerialNumber: "S3Z"})
+		Expect(disk.WWN).To(Equal("0x5000c500deadbeef"))
+		Expect(disk.Serial).To(Equal("S3Z"))
+
+		disk = diskState(fs, &block.Disk{Name: "vda", WWN: "unknown", SerialNumber: "unknown"})
+		Expect(disk.WWN).To(BeEmpty())
+		Expect(disk.Serial).To(Equal("disk-1"))
+	})
+})
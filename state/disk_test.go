@@ -0,0 +1,276 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+// sysfsDisk fakes the sysfs entries of a 10GiB disk with an oem partition, a state partition and a persistent
+// partition which is followed by 1GiB of free space
+var sysfsDisk = map[string]interface{}{
+	"/sys/devices/pci0000:00/block/sda/size":           "20971520\n",
+	"/sys/devices/pci0000:00/block/sda/removable":      "0\n",
+	"/sys/devices/pci0000:00/block/sda/sda1/partition": "1\n",
+	"/sys/devices/pci0000:00/block/sda/sda1/start":     "2048\n",
+	"/sys/devices/pci0000:00/block/sda/sda1/size":      "131072\n",
+	"/sys/devices/pci0000:00/block/sda/sda2/partition": "2\n",
+	"/sys/devices/pci0000:00/block/sda/sda2/start":     "135168\n",
+	"/sys/devices/pci0000:00/block/sda/sda2/size":      "8388608\n",
+	"/sys/devices/pci0000:00/block/sda/sda3/partition": "3\n",
+	"/sys/devices/pci0000:00/block/sda/sda3/start":     "8523776\n",
+	"/sys/devices/pci0000:00/block/sda/sda3/size":      "10350592\n",
+	"/sys/class/block/sda":                             &vfst.Symlink{Target: "../../devices/pci0000:00/block/sda"},
+	"/sys/class/block/sda1":                            &vfst.Symlink{Target: "../../devices/pci0000:00/block/sda/sda1"},
+	"/sys/class/block/sda2":                            &vfst.Symlink{Target: "../../devices/pci0000:00/block/sda/sda2"},
+	"/sys/class/block/sda3":                            &vfst.Symlink{Target: "../../devices/pci0000:00/block/sda/sda3"},
+}
+
+var _ = Describe("Disk", func() {
+	var fs vfs.FS
+	var cleanup func()
+	var r Runtime
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(sysfsDisk)
+		Expect(err).ToNot(HaveOccurred())
+		r = Runtime{
+			OEM:        PartitionState{Found: true, Name: "/dev/sda1", FilesystemLabel: "COS_OEM"},
+			State:      PartitionState{Found: true, Name: "/dev/sda2", FilesystemLabel: "COS_STATE"},
+			Persistent: PartitionState{Found: true, Name: "/dev/sda3", FilesystemLabel: "COS_PERSISTENT"},
+		}.WithFS(fs)
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	Describe("UnallocatedBytesAfter", func() {
+		It("returns the space up to the end of the disk", func() {
+			free, err := r.UnallocatedBytesAfter("COS_PERSISTENT")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(free).To(Equal(uint64(1024 * 1024 * 1024)))
+		})
+
+		It("returns the space up to the next partition", func() {
+			free, err := r.UnallocatedBytesAfter("COS_OEM")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(free).To(Equal(uint64(2048 * 512)))
+		})
+
+		It("fails for unknown labels", func() {
+			_, err := r.UnallocatedBytesAfter("COS_RECOVERY")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails if the partition is not on a detectable disk", func() {
+			r.OEM.Name = "/dev/dm-0"
+			_, err := r.UnallocatedBytesAfter("COS_OEM")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("PersistentIsDedicatedDisk", func() {
+		It("is false when persistent is next to state", func() {
+			dedicated, err := r.PersistentIsDedicatedDisk()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dedicated).To(BeFalse())
+		})
+
+		It("is true when persistent is on another disk", func() {
+			files := map[string]interface{}{
+				"/sys/devices/pci0000:00/block/sdb/sdb1/partition": "1\n",
+				"/sys/class/block/sdb1":                            &vfst.Symlink{Target: "../../devices/pci0000:00/block/sdb/sdb1"},
+			}
+			for k, v := range sysfsDisk {
+				files[k] = v
+			}
+			twoDisks, cleanupTwoDisks, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanupTwoDisks()
+
+			r.Persistent.Name = "/dev/sdb1"
+			dedicated, err := r.WithFS(twoDisks).PersistentIsDedicatedDisk()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dedicated).To(BeTrue())
+		})
+
+		It("fails when persistent can't be resolved", func() {
+			r.Persistent.Name = "/dev/sdz1"
+			_, err := r.PersistentIsDedicatedDisk()
+			Expect(err).To(MatchError(ContainSubstring("/dev/sdz1")))
+		})
+
+		It("fails when state is missing", func() {
+			r.State = PartitionState{}
+			_, err := r.PersistentIsDedicatedDisk()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("InstallDevice", func() {
+		It("returns the disk of state", func() {
+			Expect(r.InstallDevice()).To(Equal("/dev/sda"))
+		})
+
+		It("fails when state is missing", func() {
+			r.State = PartitionState{}
+			_, err := r.InstallDevice()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails when the disk of state can't be found", func() {
+			r.State.Name = "/dev/sdz2"
+			_, err := r.InstallDevice()
+			Expect(err).To(MatchError(ContainSubstring("/dev/sdz2")))
+		})
+	})
+
+	Describe("SharedDevices", func() {
+		It("is empty when every partition has its own device", func() {
+			Expect(r.SharedDevices()).To(BeEmpty())
+		})
+
+		It("reports the labels sharing a device", func() {
+			r.Persistent.Name = "/dev/sda1"
+			r.Extra = map[string]PartitionState{
+				"COS_GRUB": {Found: true, Name: "/dev/sda1"},
+				"COS_EFI":  {Found: true, Name: "/dev/sda4"},
+			}
+			Expect(r.SharedDevices()).To(Equal(map[string][]string{
+				"/dev/sda1": {"COS_GRUB", "COS_OEM", "COS_PERSISTENT"},
+			}))
+		})
+
+		It("ignores partitions that weren't found", func() {
+			r.Recovery = PartitionState{Name: "/dev/sda1", FilesystemLabel: "COS_RECOVERY"}
+			Expect(r.SharedDevices()).To(BeEmpty())
+		})
+	})
+
+	Describe("RemovableDisks", func() {
+		It("returns removable and hotplug disks only", func() {
+			r.Disks = []DiskState{
+				{Name: "/dev/sda", SizeBytes: 10 * 1024 * 1024 * 1024},
+				{Name: "/dev/sdb", Removable: true, Hotplug: true},
+				{Name: "/dev/sdc", Hotplug: true},
+				{Name: "/dev/sr0", Removable: true},
+			}
+			Expect(r.RemovableDisks()).To(Equal([]DiskState{r.Disks[1], r.Disks[2], r.Disks[3]}))
+		})
+
+		It("is empty without removable disks", func() {
+			r.Disks = []DiskState{{Name: "/dev/nvme0n1"}}
+			Expect(r.RemovableDisks()).To(BeEmpty())
+		})
+	})
+
+	Describe("PartitionTableWithVFS", func() {
+		sectors := func(size int, at map[int]string) string {
+			dat := make([]byte, size)
+			for offset, content := range at {
+				copy(dat[offset:], content)
+			}
+			return string(dat)
+		}
+
+		DescribeTable("detects the partition table",
+			func(content string, expected string) {
+				fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/dev/sda": content})
+				Expect(err).ToNot(HaveOccurred())
+				defer cleanup()
+				Expect(PartitionTableWithVFS(fs, "/dev/sda")).To(Equal(expected))
+			},
+			Entry("gpt", sectors(8192, map[int]string{510: "\x55\xaa", 512: "EFI PART"}), PartitionTableGPT),
+			Entry("gpt on 4k sectors", sectors(8192, map[int]string{510: "\x55\xaa", 4096: "EFI PART"}), PartitionTableGPT),
+			Entry("mbr", sectors(8192, map[int]string{510: "\x55\xaa"}), PartitionTableMBR),
+			Entry("blank disk", sectors(8192, nil), PartitionTableUnknown),
+			Entry("tiny device", "short", PartitionTableUnknown),
+		)
+
+		It("is unknown when the disk can't be read", func() {
+			Expect(PartitionTableWithVFS(fs, "/dev/sdz")).To(Equal(PartitionTableUnknown))
+		})
+	})
+
+	Describe("LabelConflicts", func() {
+		It("reports labels found on several devices", func() {
+			r.LabelDevices = map[string][]string{
+				"COS_STATE":      {"/dev/sda2", "/dev/sdb2"},
+				"COS_OEM":        {"/dev/sda1"},
+				"COS_PERSISTENT": {"/dev/sda3"},
+			}
+			Expect(r.LabelConflicts()).To(Equal(map[string][]string{"COS_STATE": {"/dev/sda2", "/dev/sdb2"}}))
+		})
+
+		It("is empty without duplicates", func() {
+			Expect(r.LabelConflicts()).To(BeEmpty())
+			r.LabelDevices = map[string][]string{"COS_OEM": {"/dev/sda1"}}
+			Expect(r.LabelConflicts()).To(BeEmpty())
+		})
+	})
+
+	Describe("PartitionAlignmentWithVFS", func() {
+		It("checks the start of the partition against the optimal io size", func() {
+			Expect(fs.WriteFile("/sys/devices/pci0000:00/block/sda/sda2/start", []byte("135169\n"), 0o644)).To(Succeed())
+			Expect(vfs.MkdirAll(fs, "/sys/devices/pci0000:00/block/sda/queue", 0o755)).To(Succeed())
+			Expect(fs.WriteFile("/sys/devices/pci0000:00/block/sda/queue/optimal_io_size", []byte("1048576\n"), 0o644)).To(Succeed())
+
+			offset, aligned := PartitionAlignmentWithVFS(fs, "/dev/sda1")
+			Expect(offset).To(Equal(uint64(1024 * 1024)))
+			Expect(aligned).To(BeTrue())
+
+			offset, aligned = PartitionAlignmentWithVFS(fs, "/dev/sda2")
+			Expect(offset).To(Equal(uint64(135169 * 512)))
+			Expect(aligned).To(BeFalse())
+		})
+
+		It("uses the physical sector size when there is no optimal io size", func() {
+			Expect(vfs.MkdirAll(fs, "/sys/devices/pci0000:00/block/sda/queue", 0o755)).To(Succeed())
+			Expect(fs.WriteFile("/sys/devices/pci0000:00/block/sda/queue/optimal_io_size", []byte("0\n"), 0o644)).To(Succeed())
+			Expect(fs.WriteFile("/sys/devices/pci0000:00/block/sda/queue/physical_block_size", []byte("4096\n"), 0o644)).To(Succeed())
+			Expect(fs.WriteFile("/sys/devices/pci0000:00/block/sda/sda2/start", []byte("135169\n"), 0o644)).To(Succeed())
+
+			_, aligned := PartitionAlignmentWithVFS(fs, "/dev/sda2")
+			Expect(aligned).To(BeFalse())
+		})
+
+		It("defaults to aligned when it can't be told", func() {
+			offset, aligned := PartitionAlignmentWithVFS(fs, "/dev/sda3")
+			Expect(offset).To(Equal(uint64(8523776 * 512)))
+			Expect(aligned).To(BeTrue())
+
+			offset, aligned = PartitionAlignmentWithVFS(fs, "/dev/mapper/vg-persistent")
+			Expect(offset).To(BeZero())
+			Expect(aligned).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("DeviceChainWithVFS", func() {
+	It("follows the device mapper devices down to the partition", func() {
+		files := map[string]interface{}{
+			"/sys/devices/virtual/block/dm-0/dm/name":     "luks-persistent\n",
+			"/sys/devices/virtual/block/dm-0/dm/uuid":     "CRYPT-LUKS2-0123456789abcdef-luks-persistent\n",
+			"/sys/devices/virtual/block/dm-0/slaves/sda3": &vfst.Symlink{Target: "../../../../pci0000:00/block/sda/sda3"},
+			"/sys/devices/virtual/block/dm-1/dm/name":     "vg-persistent\n",
+			"/sys/devices/virtual/block/dm-1/dm/uuid":     "LVM-0123456789abcdef\n",
+			"/sys/devices/virtual/block/dm-1/slaves/dm-0": &vfst.Symlink{Target: "../../dm-0"},
+			"/sys/class/block/dm-0":                       &vfst.Symlink{Target: "../../devices/virtual/block/dm-0"},
+			"/sys/class/block/dm-1":                       &vfst.Symlink{Target: "../../devices/virtual/block/dm-1"},
+		}
+		for k, v := range sysfsDisk {
+			files[k] = v
+		}
+		fs, cleanup, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(DeviceChainWithVFS(fs, "/dev/dm-1")).To(Equal([]string{"/dev/mapper/vg-persistent", "/dev/mapper/luks-persistent", "/dev/sda3"}))
+		Expect(DeviceChainWithVFS(fs, "/dev/sda3")).To(BeEmpty())
+		Expect(DeviceChainWithVFS(fs, "/dev/sdz1")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package state
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocatedFileBytes returns the space the file takes on its filesystem, which is less than its size for sparse files
+func allocatedFileBytes(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	// st_blocks is always in 512 bytes units, whatever the block size of the filesystem is
+	return uint64(stat.Blocks) * 512, true
+}
@@ -0,0 +1,36 @@
+package state
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// storageModules are the kernel modules of the disk controllers and storage stacks Kairos usually runs on
+var storageModules = map[string]bool{
+	"virtio_blk": true, "virtio_scsi": true, "nvme": true, "nvme_core": true, "ahci": true, "libata": true,
+	"ata_piix": true, "sd_mod": true, "sr_mod": true, "scsi_mod": true, "mpt3sas": true, "megaraid_sas": true,
+	"hpsa": true, "smartpqi": true, "aacraid": true, "vmw_pvscsi": true, "hv_storvsc": true, "xen_blkfront": true,
+	"mmc_block": true, "sdhci": true, "usb_storage": true, "uas": true, "dm_mod": true, "dm_crypt": true,
+	"md_mod": true, "raid1": true, "loop": true,
+}
+
+// StorageDriversWithVFS returns the storage related kernel modules loaded, sorted, using a vfs so it can be used
+// for tests as well. Drivers built into the kernel are not listed in /proc/modules, so they are missing here.
+// It's empty if /proc/modules can't be read.
+func StorageDriversWithVFS(fs vfs.FS) []string {
+	dat, err := fs.ReadFile("/proc/modules")
+	if err != nil {
+		return nil
+	}
+	var drivers []string
+	for _, line := range strings.Split(string(dat), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && storageModules[fields[0]] {
+			drivers = append(drivers, fields[0])
+		}
+	}
+	sort.Strings(drivers)
+	return drivers
+}
@@ -0,0 +1,31 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("StorageDriversWithVFS", func() {
+	It("lists the storage modules loaded", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/modules": "virtio_net 69632 0 - Live 0x0000000000000000\n" +
+				"virtio_blk 24576 3 - Live 0x0000000000000000\n" +
+				"dm_crypt 61440 0 - Live 0x0000000000000000\n" +
+				"nvme 53248 2 - Live 0x0000000000000000\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(StorageDriversWithVFS(fs)).To(Equal([]string{"dm_crypt", "nvme", "virtio_blk"}))
+	})
+
+	It("is empty without /proc/modules", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cpuinfo": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(StorageDriversWithVFS(fs)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"unicode/utf16"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	efiVarsDir = "/sys/firmware/efi/efivars"
+	// efiGlobalVariable is the vendor GUID of the boot manager variables
+	efiGlobalVariable = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	// efiVarAttributesSize is the size of the attributes efivarfs prefixes every variable with
+	efiVarAttributesSize = 4
+)
+
+// readEFIVar reads the data of a global EFI variable, without the attributes
+func readEFIVar(fs vfs.FS, name string) ([]byte, error) {
+	return readVendorEFIVar(fs, efiGlobalVariable, name)
+}
+
+// readVendorEFIVar reads the data of an EFI variable of the given vendor GUID, without the attributes
+func readVendorEFIVar(fs vfs.FS, vendor, name string) ([]byte, error) {
+	dat, err := fs.ReadFile(filepath.Join(efiVarsDir, fmt.Sprintf("%s-%s", name, vendor)))
+	if err != nil {
+		return nil, err
+	}
+	if len(dat) < efiVarAttributesSize {
+		return nil, fmt.Errorf("efi variable %s is too short", name)
+	}
+	return dat[efiVarAttributesSize:], nil
+}
+
+// efiBootEntry names a boot entry like efibootmgr does, Boot0001 followed by its description when it can be read
+func efiBootEntry(fs vfs.FS, num uint16) string {
+	name := fmt.Sprintf("Boot%04X", num)
+	dat, err := readEFIVar(fs, name)
+	// EFI_LOAD_OPTION: 4 bytes of attributes, 2 of file path list length and the NUL terminated UCS-2 description
+	if err != nil || len(dat) < 6 {
+		return name
+	}
+	desc := efiString(dat[6:])
+	if desc == "" {
+		return name
+	}
+	return fmt.Sprintf("%s %s", name, desc)
+}
+
+// efiString decodes a NUL terminated UCS-2 string, as the firmware and the boot loaders store them
+func efiString(dat []byte) string {
+	s := []uint16{}
+	for i := 0; i+1 < len(dat); i += 2 {
+		c := binary.LittleEndian.Uint16(dat[i:])
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+	return string(utf16.Decode(s))
+}
+
+// DetectEFIBootWithVFS reads the EFI boot order and the entry booted this time from the efivars using a vfs so it
+// can be used for tests as well. Both are empty on BIOS systems.
+func DetectEFIBootWithVFS(fs vfs.FS) (order []string, current string) {
+	if dat, err := readEFIVar(fs, "BootOrder"); err == nil {
+		for i := 0; i+1 < len(dat); i += 2 {
+			order = append(order, efiBootEntry(fs, binary.LittleEndian.Uint16(dat[i:])))
+		}
+	}
+	if dat, err := readEFIVar(fs, "BootCurrent"); err == nil && len(dat) >= 2 {
+		current = efiBootEntry(fs, binary.LittleEndian.Uint16(dat))
+	}
+	return order, current
+}
@@ -0,0 +1,59 @@
+package state_test
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+const efiGUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// efiVar builds the content of an efivarfs file, the attributes followed by the given uint16s
+func efiVar(values ...uint16) string {
+	dat := []byte{0x07, 0x00, 0x00, 0x00}
+	for _, v := range values {
+		dat = binary.LittleEndian.AppendUint16(dat, v)
+	}
+	return string(dat)
+}
+
+// efiLoadOption builds the content of a Boot#### efivarfs file with the given description
+func efiLoadOption(description string) string {
+	dat := []byte{0x07, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x10, 0x00}
+	for _, c := range utf16.Encode([]rune(description)) {
+		dat = binary.LittleEndian.AppendUint16(dat, c)
+	}
+	// terminator and the start of the device path, which is ignored
+	return string(append(dat, 0x00, 0x00, 0x04, 0x01))
+}
+
+var _ = Describe("DetectEFIBootWithVFS", func() {
+	It("reads the boot order and the current entry", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/sys/firmware/efi/efivars/BootOrder-" + efiGUID:   efiVar(0x0003, 0x0001, 0x000A),
+			"/sys/firmware/efi/efivars/BootCurrent-" + efiGUID: efiVar(0x0001),
+			"/sys/firmware/efi/efivars/Boot0001-" + efiGUID:    efiLoadOption("Kairos"),
+			"/sys/firmware/efi/efivars/Boot0003-" + efiGUID:    efiLoadOption("UEFI PXEv4 (MAC:525400123456)"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		order, current := DetectEFIBootWithVFS(fs)
+		Expect(order).To(Equal([]string{"Boot0003 UEFI PXEv4 (MAC:525400123456)", "Boot0001 Kairos", "Boot000A"}))
+		Expect(current).To(Equal("Boot0001 Kairos"))
+	})
+
+	It("is empty on BIOS systems", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/sys/firmware/acpi/tables/DSDT": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		order, current := DetectEFIBootWithVFS(fs)
+		Expect(order).To(BeEmpty())
+		Expect(current).To(BeEmpty())
+	})
+})
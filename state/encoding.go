@@ -0,0 +1,98 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runtimeFields has the same fields as Runtime but none of its methods, so the marshallers below can
+// fall back to the default encoding without recursing into themselves
+type runtimeFields Runtime
+
+// MarshalText implements encoding.TextMarshaler using the same YAML as String()
+func (r Runtime) MarshalText() ([]byte, error) {
+	return yaml.Marshal(runtimeFields(r))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, reading back the output of MarshalText
+func (r *Runtime) UnmarshalText(text []byte) error {
+	return yaml.Unmarshal(text, (*runtimeFields)(r))
+}
+
+// MarshalJSON keeps the JSON encoding structured, otherwise encoding/json would use MarshalText
+func (r Runtime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(runtimeFields(r))
+}
+
+func (r *Runtime) UnmarshalJSON(dat []byte) error {
+	return json.Unmarshal(dat, (*runtimeFields)(r))
+}
+
+// MarshalYAML keeps the YAML encoding structured, otherwise yaml would use MarshalText
+func (r Runtime) MarshalYAML() (interface{}, error) {
+	return runtimeFields(r), nil
+}
+
+func (r *Runtime) UnmarshalYAML(node *yaml.Node) error {
+	return node.Decode((*runtimeFields)(r))
+}
+
+// partitionFields has the same fields as PartitionState but none of its methods, like runtimeFields
+type partitionFields PartitionState
+
+// legacyPartitionFields are the names the fields of PartitionState had before being made snake_case, they are
+// still read so runtimes written by older versions can be loaded
+type legacyPartitionFields struct {
+	FilesystemLabel string `yaml:"filesystemlabel" json:"filesystemlabel"`
+}
+
+// fillLegacy sets the fields that were only found under their legacy names
+func (p *PartitionState) fillLegacy(legacy legacyPartitionFields) {
+	if p.FilesystemLabel == "" {
+		p.FilesystemLabel = legacy.FilesystemLabel
+	}
+}
+
+func (p *PartitionState) UnmarshalJSON(dat []byte) error {
+	if err := json.Unmarshal(dat, (*partitionFields)(p)); err != nil {
+		return err
+	}
+	legacy := legacyPartitionFields{}
+	if err := json.Unmarshal(dat, &legacy); err != nil {
+		return err
+	}
+	p.fillLegacy(legacy)
+	return nil
+}
+
+func (p *PartitionState) UnmarshalYAML(node *yaml.Node) error {
+	if err := node.Decode((*partitionFields)(p)); err != nil {
+		return err
+	}
+	legacy := legacyPartitionFields{}
+	if err := node.Decode(&legacy); err != nil {
+		return err
+	}
+	p.fillLegacy(legacy)
+	return nil
+}
+
+// Encode serializes the runtime into a compact binary form, meant for caching it between stages.
+// Use String() for anything that needs to be human readable.
+func (r Runtime) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode reads back a runtime serialized with Encode
+func Decode(dat []byte) (Runtime, error) {
+	r := Runtime{}
+	err := gob.NewDecoder(bytes.NewReader(dat)).Decode(&r)
+	return r, err
+}
@@ -0,0 +1,179 @@
+package state_test
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Encoding", func() {
+	var r Runtime
+
+	BeforeEach(func() {
+		// Every field of the runtime and of persistent is set, so the round trips can't hide a field that doesn't
+		// survive them
+		persistent := PartitionState{
+			Mounted:           true,
+			Name:              "/dev/dm-0",
+			Label:             "persistent",
+			FilesystemLabel:   "COS_PERSISTENT",
+			MountPoint:        "/usr/local",
+			OtherMountPoints:  []string{"/var/lib/rancher"},
+			SizeBytes:         1024,
+			Type:              "ext4",
+			IsReadOnly:        true,
+			Found:             true,
+			UUID:              "0b8a7e9c-56d2-4a5e-9f1e-2c3d4e5f6a7b",
+			Propagation:       "shared",
+			NeedsCheck:        true,
+			FSFeatures:        []string{"has_journal", "extent"},
+			DeviceLink:        "/dev/mapper/luks-persistent",
+			Encrypted:         true,
+			UnlockMethod:      "tpm",
+			Role:              "persistent",
+			UsedBytes:         512,
+			FreeBytes:         256,
+			IOLatency:         3 * time.Millisecond,
+			StartOffsetBytes:  1048576,
+			Aligned:           true,
+			RemountedReadOnly: true,
+			DeviceChain:       []string{"/dev/mapper/luks-persistent", "/dev/sda5"},
+		}
+		r = Runtime{
+			UUID:       "uuid",
+			Persistent: persistent,
+			Recovery:   PartitionState{Found: true, Name: "/dev/sda3", FilesystemLabel: "COS_RECOVERY"},
+			OEM:        PartitionState{Found: true, Name: "/dev/sda2", FilesystemLabel: "COS_OEM"},
+			State:      PartitionState{Found: true, Name: "/dev/sda4", FilesystemLabel: "COS_STATE"},
+			BootState:  Active,
+			Bootloader: BootloaderGrub,
+			Init:       InitSystemd,
+			System: SystemInfo{
+				OS:      SystemOS{Name: "openSUSE Leap", Vendor: "opensuse", Version: "15.5", Release: "15.5", Architecture: "amd64"},
+				Kernel:  SystemKernel{Release: "5.14.21", Version: "#1 SMP", Architecture: "x86_64"},
+				Product: SystemProduct{Name: "Standard PC", Vendor: "QEMU", Version: "pc-q35-7.2", Serial: "1234"},
+				Board:   SystemBoard{Name: "X570", Vendor: "ASRock", Version: "1.0", Serial: "5678", AssetTag: "asset"},
+				CPU:     SystemCPU{Vendor: "GenuineIntel", Model: "Xeon", Speed: 2400, Cache: 8192, Cpus: 1, Cores: 4, Threads: 8},
+				Memory:  SystemMemory{Type: "DDR4", Speed: 3200, Size: 8192},
+			},
+			Kairos:  Kairos{Flavor: "opensuse", Version: "v2.4.0"},
+			Cluster: ClusterState{Provider: ClusterK3s, Role: ClusterRoleServer},
+			Network: NetworkState{
+				Interfaces: []NetworkInterface{
+					{Name: "bond0", MAC: "52:54:00:12:34:56", IPv4: []string{"10.0.0.2/24"}, IPv6: []string{"fd00::2/64"}, Kind: "bond", Members: []string{"eth0"}},
+					{Name: "eth0", Master: "bond0"},
+					{Name: "bond0.10", Kind: "vlan", Parent: "bond0", VLANID: 10},
+				},
+				DefaultInterface: "bond0",
+			},
+			Uptime:              time.Hour,
+			BootTime:            time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+			EFIBootOrder:        []string{"Boot0001", "Boot0000"},
+			EFICurrent:          "Boot0001",
+			BootAttemptsLeft:    1,
+			BootAttemptsMax:     3,
+			Timezone:            "Europe/Madrid",
+			Locale:              "en_US.UTF-8",
+			CPUCount:            8,
+			MemoryBytes:         8589934592,
+			BootConfigProtected: true,
+			Immutable:           true,
+			DegradedBoot:        true,
+			StorageDrivers:      []string{"nvme", "virtio_blk"},
+			Disks: []DiskState{{
+				Name: "/dev/sda", SizeBytes: 10737418240, Model: "QEMU HARDDISK", Removable: true, Hotplug: true,
+				PartitionTable: "gpt", PhysicalSectorSize: 4096, LogicalSectorSize: 512, Aligned: true,
+				Allocated: true, AllocatedBytes: 4096, WWN: "0x5000c500a1b2c3d4", Serial: "WD-WX12345678",
+			}},
+			ZFSPools: []ZFSPool{{Name: "tank", Health: "ONLINE", SizeBytes: 4096, Datasets: []ZFSDataset{
+				{Name: "tank/data", UsedBytes: 1024, AvailableBytes: 2048, MountPoint: "/tank/data"},
+			}}},
+			LabelDevices: map[string][]string{"COS_PERSISTENT": {"/dev/sda5", "/dev/sdb1"}},
+			Extra:        map[string]PartitionState{"COS_DATA": {Found: true, Name: "/dev/sdb2"}},
+			Timings:      map[string]time.Duration{"ghw": time.Second},
+			Partial:      true,
+			Warnings:     []string{"ghw failed"},
+			DetectionLog: map[string]string{"lsblk -J": "{}"},
+		}
+	})
+
+	It("round trips through json", func() {
+		dat, err := json.Marshal(r)
+		Expect(err).ToNot(HaveOccurred())
+		decoded := Runtime{}
+		Expect(json.Unmarshal(dat, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(r))
+	})
+
+	It("round trips through gob", func() {
+		dat, err := r.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		decoded, err := Decode(dat)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded).To(Equal(r))
+	})
+
+	It("round trips through yaml", func() {
+		dat, err := yaml.Marshal(r)
+		Expect(err).ToNot(HaveOccurred())
+		decoded := Runtime{}
+		Expect(yaml.Unmarshal(dat, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(r))
+	})
+
+	It("uses the same snake_case names in json and yaml", func() {
+		dat, err := json.Marshal(r)
+		Expect(err).ToNot(HaveOccurred())
+		fromJSON := map[string]interface{}{}
+		Expect(json.Unmarshal(dat, &fromJSON)).To(Succeed())
+
+		dat, err = yaml.Marshal(r)
+		Expect(err).ToNot(HaveOccurred())
+		fromYAML := map[string]interface{}{}
+		Expect(yaml.Unmarshal(dat, &fromYAML)).To(Succeed())
+
+		snakeCase := `^[a-z0-9]+(_[a-z0-9]+)*$`
+		// maps keyed by data, like labels or commands, whose keys aren't field names
+		dataKeyed := map[string]bool{".label_devices": true, ".extra": true, ".timings": true, ".detection_log": true}
+		var keys func(prefix string, v interface{}) []string
+		keys = func(prefix string, v interface{}) []string {
+			var found []string
+			switch value := v.(type) {
+			case map[string]interface{}:
+				for k, child := range value {
+					if !dataKeyed[prefix] {
+						Expect(k).To(MatchRegexp(snakeCase), prefix)
+					}
+					found = append(found, keys(prefix+"."+k, child)...)
+				}
+				found = append(found, prefix)
+			case []interface{}:
+				for _, child := range value {
+					found = append(found, keys(prefix+"[]", child)...)
+				}
+			}
+			return found
+		}
+		Expect(keys("", fromYAML)).To(ConsistOf(keys("", fromJSON)))
+	})
+
+	It("reads the legacy field names", func() {
+		decoded := Runtime{}
+		Expect(json.Unmarshal([]byte(`{"persistent": {"filesystemlabel": "COS_PERSISTENT"}}`), &decoded)).To(Succeed())
+		Expect(decoded.Persistent.FilesystemLabel).To(Equal("COS_PERSISTENT"))
+
+		decoded = Runtime{}
+		Expect(yaml.Unmarshal([]byte("oem:\n  filesystemlabel: COS_OEM\n"), &decoded)).To(Succeed())
+		Expect(decoded.OEM.FilesystemLabel).To(Equal("COS_OEM"))
+	})
+
+	It("prefers the current field names", func() {
+		decoded := Runtime{}
+		Expect(json.Unmarshal([]byte(`{"state": {"filesystemlabel": "OLD", "filesystem_label": "COS_STATE"}}`), &decoded)).To(Succeed())
+		Expect(decoded.State.FilesystemLabel).To(Equal("COS_STATE"))
+	})
+})
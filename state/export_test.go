@@ -0,0 +1,20 @@
+package state
+
+import (
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/block"
+)
+
+// WithHost replaces what the probe reads from the machine itself instead of through the filesystem or the runner:
+// the disks ghw finds, the hardware sysinfo reports and the network interfaces. It keeps the probing tests from
+// depending on the machine they run on.
+func WithHost(disks []*block.Disk, system SystemInfo, interfaces []NetworkInterface) Option {
+	return func(o *Options) error {
+		o.blockDevices = func(...*ghw.WithOption) (*block.Info, error) {
+			return &block.Info{Disks: disks}, nil
+		}
+		o.systemInfo = func() SystemInfo { return system }
+		o.interfaces = func(bool) []NetworkInterface { return interfaces }
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// isExt returns whether the filesystem type is one that e2fsprogs know how to inspect
+func isExt(fsType string) bool {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		return true
+	}
+	return false
+}
+
+// parseDumpe2fsHeader parses the "Key:   value" lines printed by dumpe2fs -h into a map
+func parseDumpe2fsHeader(out string) map[string]string {
+	header := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		header[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return header
+}
+
+// detectFilesystemState flags ext filesystems that were not cleanly unmounted or have errors recorded, and lists
+// their enabled features. Any failure, like dumpe2fs not being installed, just leaves the partition as not needing
+// a check and without features.
+func detectFilesystemState(ctx context.Context, runner CommandRunner, p *PartitionState) {
+	if !p.Found || p.Name == "" || !isExt(p.Type) {
+		return
+	}
+	out, err := runner(ctx, fmt.Sprintf("dumpe2fs -h %s", p.Name))
+	if err != nil {
+		return
+	}
+	header := parseDumpe2fsHeader(out)
+	if features, ok := header["Filesystem features"]; ok {
+		p.FSFeatures = strings.Fields(features)
+	}
+	if state, ok := header["Filesystem state"]; ok {
+		p.NeedsCheck = state != "clean"
+	}
+}
+
+// freshEntries are the only entries of a persistent partition that was just formatted, lost+found from mkfs and
+// the .state dir where the bind mounts are set up on every boot
+var freshEntries = map[string]bool{"lost+found": true, ".state": true}
+
+// PersistentIsFresh returns whether persistent was formatted during this boot, like on the first boot after an
+// install or a reset. For ext filesystems it's told by their creation time, otherwise, or when dumpe2fs can't
+// be run, by persistent holding nothing but what any freshly formatted one does.
+func (r Runtime) PersistentIsFresh() (bool, error) {
+	p := r.Persistent
+	if !p.Mounted || p.MountPoint == "" {
+		return false, fmt.Errorf("persistent partition is not mounted")
+	}
+	if isExt(p.Type) && !r.BootTime.IsZero() {
+		out, err := r.commandRunner()(context.Background(), fmt.Sprintf("dumpe2fs -h %s", p.Name))
+		if err == nil {
+			// dumpe2fs prints the times in the local timezone
+			created, err := time.ParseInLocation(time.ANSIC, parseDumpe2fsHeader(out)["Filesystem created"], time.Local)
+			if err == nil {
+				return created.After(r.BootTime), nil
+			}
+		}
+	}
+	entries, err := r.filesystem().ReadDir(p.MountPoint)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if !freshEntries[e.Name()] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
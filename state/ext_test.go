@@ -0,0 +1,58 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("PersistentIsFresh", func() {
+	bootTime := time.Date(2023, time.July, 3, 10, 0, 0, 0, time.Local)
+	persistent := PartitionState{Found: true, Mounted: true, Name: "/dev/sda5", MountPoint: "/usr/local", Type: "ext4"}
+
+	dumpe2fs := func(created string) CommandRunner {
+		return func(_ context.Context, command string) (string, error) {
+			Expect(command).To(Equal("dumpe2fs -h /dev/sda5"))
+			return "Filesystem volume name:   COS_PERSISTENT\nFilesystem created:       " + created + "\nFilesystem state:         clean\n", nil
+		}
+	}
+
+	It("uses the creation time of ext filesystems", func() {
+		r := Runtime{Persistent: persistent, BootTime: bootTime}
+		fresh, err := r.WithCommandRunner(dumpe2fs("Mon Jul  3 10:00:42 2023")).PersistentIsFresh()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fresh).To(BeTrue())
+
+		fresh, err = r.WithCommandRunner(dumpe2fs("Thu Jun  1 08:12:00 2023")).PersistentIsFresh()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fresh).To(BeFalse())
+	})
+
+	DescribeTable("falls back to what's on persistent without dumpe2fs",
+		func(files map[string]interface{}, expected bool) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			failing := func(_ context.Context, _ string) (string, error) {
+				return "", errors.New("exit status 127")
+			}
+			r := Runtime{Persistent: persistent, BootTime: bootTime}.WithFS(fs).WithCommandRunner(failing)
+			fresh, err := r.PersistentIsFresh()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fresh).To(Equal(expected))
+		},
+		Entry("just formatted", map[string]interface{}{"/usr/local/lost+found": &vfst.Dir{Perm: 0o700}, "/usr/local/.state": &vfst.Dir{Perm: 0o755}}, true),
+		Entry("with data", map[string]interface{}{"/usr/local/lost+found": &vfst.Dir{Perm: 0o700}, "/usr/local/.kairos/sentinel_install": ""}, false),
+	)
+
+	It("fails if persistent is not mounted", func() {
+		_, err := Runtime{Persistent: PartitionState{Found: true}}.PersistentIsFresh()
+		Expect(err).To(HaveOccurred())
+	})
+})
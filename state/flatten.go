@@ -0,0 +1,53 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// identifierRegexp matches the object keys that can be written as .key in a query, the rest have to be quoted
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Flatten returns the json representation of the runtime as flat key/value pairs, like persistent.mounted=true,
+// for stores that can't take nested documents. Keys are the paths Query takes to get each value: array items are
+// indexed like disks[0].name and keys that aren't identifiers are quoted like label_devices["COS-DATA"][0].
+// Nulls are flattened to empty strings, and empty arrays and objects are left out.
+func (r Runtime) Flatten() map[string]string {
+	flat := map[string]string{}
+	// The runtime only holds plain types, so it always encodes
+	dat, _ := json.Marshal(r)
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	// Keep the numbers as they are, sizes don't fit in a float64 without losing precision
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err == nil {
+		flatten(flat, "", v)
+	}
+	return flat
+}
+
+func flatten(flat map[string]string, prefix string, v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			key := fmt.Sprintf("%s[%q]", prefix, k)
+			if identifierRegexp.MatchString(k) {
+				key = k
+				if prefix != "" {
+					key = prefix + "." + k
+				}
+			}
+			flatten(flat, key, child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			flatten(flat, fmt.Sprintf("%s[%d]", prefix, i), child)
+		}
+	case nil:
+		flat[prefix] = ""
+	default:
+		flat[prefix] = fmt.Sprint(value)
+	}
+}
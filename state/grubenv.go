@@ -0,0 +1,65 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// grubEnvFiles are the grubenv files grub loads from each partition, in the order it loads them
+var grubEnvFiles = []struct {
+	partition func(r Runtime) PartitionState
+	paths     []string
+}{
+	{partition: func(r Runtime) PartitionState { return r.State }, paths: []string{"grub_oem_env", "grubenv", "grub2/grubenv", "grub/grubenv"}},
+	{partition: func(r Runtime) PartitionState { return r.OEM }, paths: []string{"grub_oem_env", "grubenv"}},
+}
+
+// GrubEnv reads the grub environment variables from the grubenv files on the mounted state and OEM partitions.
+// Variables are merged in the same order grub loads them, so values from OEM override those from state.
+func (r Runtime) GrubEnv() (map[string]string, error) {
+	fs := r.filesystem()
+	env := map[string]string{}
+	found := false
+	for _, g := range grubEnvFiles {
+		part := g.partition(r)
+		if !part.Mounted {
+			continue
+		}
+		for _, p := range g.paths {
+			dat, err := fs.ReadFile(filepath.Join(part.MountPoint, p))
+			if err != nil {
+				continue
+			}
+			found = true
+			for k, v := range ParseGrubEnv(dat) {
+				env[k] = v
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no grubenv found")
+	}
+	return env, nil
+}
+
+// ParseGrubEnv parses the content of a grub environment block. The block is padded with # up to its size,
+// which gets ignored along with the header like any other comment.
+func ParseGrubEnv(dat []byte) map[string]string {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		env[k] = v
+	}
+	return env
+}
@@ -0,0 +1,50 @@
+package state_test
+
+import (
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+func grubEnvBlock(vars ...string) string {
+	block := "# GRUB Environment Block\n" + strings.Join(vars, "\n") + "\n"
+	return block + strings.Repeat("#", 1024-len(block))
+}
+
+var _ = Describe("GrubEnv", func() {
+	It("parses a grub environment block", func() {
+		Expect(ParseGrubEnv([]byte(grubEnvBlock("next_entry=recovery", "extra_cmdline=console=tty1 rd.debug")))).To(Equal(map[string]string{
+			"next_entry":    "recovery",
+			"extra_cmdline": "console=tty1 rd.debug",
+		}))
+	})
+
+	It("merges the state and oem grubenv files", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/run/initramfs/cos-state/grub_oem_env": grubEnvBlock("default_fallback=2", "next_entry=passive"),
+			"/oem/grubenv":                          grubEnvBlock("next_entry=recovery"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		r := Runtime{
+			State: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"},
+			OEM:   PartitionState{Found: true, Mounted: true, MountPoint: "/oem"},
+		}.WithFS(fs)
+		env, err := r.GrubEnv()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(Equal(map[string]string{"default_fallback": "2", "next_entry": "recovery"}))
+	})
+
+	It("fails when there is no grubenv", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem/90_custom.yaml": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		_, err = Runtime{OEM: PartitionState{Found: true, Mounted: true, MountPoint: "/oem"}}.WithFS(fs).GrubEnv()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SignatureSigned   = "signed"
+	SignatureUnsigned = "unsigned"
+	SignatureUnknown  = "unknown"
+)
+
+const (
+	RecoveryFormatSquashfs = "squashfs"
+	RecoveryFormatImg      = "img"
+	RecoveryFormatUnknown  = "unknown"
+)
+
+// stateImagesDir is where the images live inside the state partition
+const stateImagesDir = "cOS"
+
+// stateImages are the images that are booted from the state partition
+var stateImages = []string{"active", "passive"}
+
+// StateFreeKey is the key StateImageUsage reports the free space of the state partition under
+const StateFreeKey = "free"
+
+// stagedImages are the images that can be found in the state partition when measuring its usage
+var stagedImages = []string{"active", "passive", "recovery"}
+
+// signatureSidecars are the extensions of the files that can carry the signature or attestation of an image
+var signatureSidecars = []string{".sig", ".asc", ".att", ".bundle"}
+
+// ImageSignatureStatus reports, for each image in the state partition, whether it carries a signature
+// or cosign attestation next to it. Note that it only checks for their presence, not that they are valid.
+// Images are reported as unknown when they can't be inspected, like when state is not mounted.
+func (r Runtime) ImageSignatureStatus() (map[string]string, error) {
+	status := map[string]string{}
+	for _, img := range stateImages {
+		status[img] = SignatureUnknown
+	}
+	if !r.State.Mounted {
+		return status, nil
+	}
+
+	fs := r.filesystem()
+	for _, img := range stateImages {
+		path := filepath.Join(r.State.MountPoint, stateImagesDir, img+".img")
+		if _, err := fs.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return status, err
+		}
+		status[img] = SignatureUnsigned
+		for _, ext := range signatureSidecars {
+			if exists(fs, path+ext) {
+				status[img] = SignatureSigned
+				break
+			}
+		}
+	}
+	return status, nil
+}
+
+// RecoveryFormat returns whether the recovery system is a squashfs or a raw img, by looking at the image on the
+// mounted recovery partition. It's unknown if there is neither.
+func (r Runtime) RecoveryFormat() (string, error) {
+	if !r.Recovery.Found {
+		return RecoveryFormatUnknown, fmt.Errorf("recovery partition not found")
+	}
+	if !r.Recovery.Mounted {
+		return RecoveryFormatUnknown, fmt.Errorf("recovery partition is not mounted")
+	}
+	fs := r.filesystem()
+	switch {
+	case exists(fs, filepath.Join(r.Recovery.MountPoint, stateImagesDir, "recovery.squashfs")):
+		return RecoveryFormatSquashfs, nil
+	case exists(fs, filepath.Join(r.Recovery.MountPoint, stateImagesDir, "recovery.img")):
+		return RecoveryFormatImg, nil
+	default:
+		return RecoveryFormatUnknown, nil
+	}
+}
+
+// StateImageUsage returns the size in bytes of the images present in the state partition, by name (active, passive
+// and recovery), along with the bytes still available on the partition under StateFreeKey. Upgrades need room for
+// a new image next to the current ones, so that's what to check before staging one.
+func (r Runtime) StateImageUsage() (map[string]uint64, error) {
+	if !r.State.Mounted {
+		return nil, fmt.Errorf("state partition is not mounted")
+	}
+	fs := r.filesystem()
+	usage := map[string]uint64{}
+	for _, img := range stagedImages {
+		info, err := fs.Stat(filepath.Join(r.State.MountPoint, stateImagesDir, img+".img"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		usage[img] = uint64(info.Size())
+	}
+
+	_, free, err := filesystemUsage(fs, r.State.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+	usage[StateFreeKey] = free
+	return usage, nil
+}
+
+// BootPartitionLowSpace returns whether the EFI partition has less than threshold bytes free, which makes kernel
+// updates fail. It's false when the EFI partition was not found mounted.
+func (r Runtime) BootPartitionLowSpace(threshold uint64) bool {
+	efi, ok := r.Extra[EFIPartitionKey]
+	return ok && efi.Mounted && efi.FreeBytes < threshold
+}
@@ -0,0 +1,103 @@
+package state_test
+
+import (
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Images", func() {
+	Describe("ImageSignatureStatus", func() {
+		It("reports signed and unsigned images", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/run/initramfs/cos-state/cOS/active.img":     "",
+				"/run/initramfs/cos-state/cOS/active.img.sig": "",
+				"/run/initramfs/cos-state/cOS/passive.img":    "",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r := Runtime{State: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"}}.WithFS(fs)
+			status, err := r.ImageSignatureStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(map[string]string{"active": SignatureSigned, "passive": SignatureUnsigned}))
+		})
+
+		It("reports unknown when state is not mounted", func() {
+			status, err := Runtime{}.ImageSignatureStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(map[string]string{"active": SignatureUnknown, "passive": SignatureUnknown}))
+		})
+	})
+
+	Describe("RecoveryFormat", func() {
+		format := func(files map[string]interface{}) string {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r := Runtime{Recovery: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"}}.WithFS(fs)
+			f, err := r.RecoveryFormat()
+			Expect(err).ToNot(HaveOccurred())
+			return f
+		}
+
+		It("detects the recovery layout", func() {
+			Expect(format(map[string]interface{}{"/run/initramfs/cos-state/cOS/recovery.squashfs": ""})).To(Equal(RecoveryFormatSquashfs))
+			Expect(format(map[string]interface{}{"/run/initramfs/cos-state/cOS/recovery.img": ""})).To(Equal(RecoveryFormatImg))
+			Expect(format(map[string]interface{}{"/run/initramfs/cos-state/cOS/": &vfst.Dir{Perm: 0o755}})).To(Equal(RecoveryFormatUnknown))
+		})
+
+		It("fails when recovery is not there", func() {
+			_, err := Runtime{}.RecoveryFormat()
+			Expect(err).To(HaveOccurred())
+			_, err = Runtime{Recovery: PartitionState{Found: true}}.RecoveryFormat()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("StateImageUsage", func() {
+		It("returns the size of the images and the free space", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/run/initramfs/cos-state/cOS/active.img":  strings.Repeat("a", 2048),
+				"/run/initramfs/cos-state/cOS/passive.img": strings.Repeat("p", 1024),
+				"/run/initramfs/cos-state/cOS/other.img":   "x",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r := Runtime{State: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"}}.WithFS(fs)
+			usage, err := r.StateImageUsage()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usage).To(HaveLen(3))
+			Expect(usage).To(HaveKeyWithValue("active", uint64(2048)))
+			Expect(usage).To(HaveKeyWithValue("passive", uint64(1024)))
+			Expect(usage).To(HaveKey(StateFreeKey))
+			Expect(usage[StateFreeKey]).To(BeNumerically(">", 0))
+		})
+
+		It("fails when state is not mounted", func() {
+			_, err := Runtime{State: PartitionState{Found: true}}.StateImageUsage()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("BootPartitionLowSpace", func() {
+		It("is low when the EFI partition has less free space than the threshold", func() {
+			r := Runtime{Extra: map[string]PartitionState{
+				EFIPartitionKey: {Found: true, Mounted: true, MountPoint: "/efi", FreeBytes: 10 * 1024 * 1024},
+			}}
+			Expect(r.BootPartitionLowSpace(64 * 1024 * 1024)).To(BeTrue())
+			Expect(r.BootPartitionLowSpace(1024 * 1024)).To(BeFalse())
+		})
+
+		It("is not low when the EFI partition was not found mounted", func() {
+			Expect(Runtime{}.BootPartitionLowSpace(64 * 1024 * 1024)).To(BeFalse())
+			r := Runtime{Extra: map[string]PartitionState{EFIPartitionKey: {Found: true}}}
+			Expect(r.BootPartitionLowSpace(64 * 1024 * 1024)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package state
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// filesystemUsage returns the bytes used on the filesystem mounted at the path and the ones still available
+func filesystemUsage(fs vfs.FS, mountpoint string) (used, free uint64, err error) {
+	path, err := fs.RawPath(mountpoint)
+	if err != nil {
+		return 0, 0, err
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("could not get the free space of %s: %w", mountpoint, err)
+	}
+	return (stat.Blocks - stat.Bfree) * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
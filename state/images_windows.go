@@ -0,0 +1,12 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// filesystemUsage can't tell the usage of a filesystem on windows, there is no statfs
+func filesystemUsage(fs vfs.FS, mountpoint string) (used, free uint64, err error) {
+	return 0, 0, fmt.Errorf("could not get the free space of %s: not supported on windows", mountpoint)
+}
@@ -0,0 +1,33 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitUnknown = "unknown"
+)
+
+// DetectInitWithVFS detects the init system running as PID 1 using a vfs so it can be used for tests as well
+func DetectInitWithVFS(fs vfs.FS) string {
+	comm, err := fs.ReadFile("/proc/1/comm")
+	if err != nil {
+		return InitUnknown
+	}
+	switch strings.TrimSpace(string(comm)) {
+	case "systemd":
+		return InitSystemd
+	case "openrc-init":
+		return InitOpenRC
+	case "init":
+		// openrc usually runs on top of sysvinit or busybox init, it leaves its state in /run/openrc
+		if exists(fs, "/run/openrc") {
+			return InitOpenRC
+		}
+	}
+	return InitUnknown
+}
@@ -0,0 +1,25 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectInitWithVFS", func() {
+	DescribeTable("detects the init system",
+		func(files map[string]interface{}, expected string) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectInitWithVFS(fs)).To(Equal(expected))
+		},
+		Entry("systemd", map[string]interface{}{"/proc/1/comm": "systemd\n"}, InitSystemd),
+		Entry("openrc-init", map[string]interface{}{"/proc/1/comm": "openrc-init\n"}, InitOpenRC),
+		Entry("openrc on sysvinit", map[string]interface{}{"/proc/1/comm": "init\n", "/run/openrc/softlevel": "default"}, InitOpenRC),
+		Entry("plain sysvinit", map[string]interface{}{"/proc/1/comm": "init\n"}, InitUnknown),
+		Entry("a shell in a container", map[string]interface{}{"/proc/1/comm": "bash\n"}, InitUnknown),
+		Entry("no procfs", map[string]interface{}{"/etc/os-release": ""}, InitUnknown),
+	)
+})
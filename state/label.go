@@ -0,0 +1,84 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxLabelLength is the longest filesystem label ext4 takes, other filesystems like vfat and xfs take even shorter ones
+const MaxLabelLength = 16
+
+// labelRegexp are the characters allowed in labels, which keeps anything the shell would interpret out of them
+var labelRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateLabel checks that the label is one a Kairos partition can have, before it's used to look the partition
+// up. It rejects labels that are too long or with characters outside of letters, digits, '_', '.' and '-'.
+func ValidateLabel(label string) error {
+	switch {
+	case label == "":
+		return fmt.Errorf("label is empty")
+	case len(label) > MaxLabelLength:
+		return fmt.Errorf("label %q is longer than %d characters", label, MaxLabelLength)
+	case !labelRegexp.MatchString(label):
+		return fmt.Errorf("label %q has invalid characters, only letters, digits, '_', '.' and '-' are allowed", label)
+	}
+	return nil
+}
+
+// labelsDir is where udev links every labelled filesystem
+const labelsDir = "/dev/disk/by-label"
+
+// AllLabels returns every filesystem label on the system, Kairos ones and foreign ones like the leftovers of a
+// previous install, with the device carrying it. A label on several devices is only reported once, LabelConflicts
+// has those. Labels are read from lsblk or, if it can't be run, from the udev links. It only fails if neither works.
+func (r Runtime) AllLabels() (map[string]string, error) {
+	labels := map[string]string{}
+	out, err := r.commandRunner()(context.Background(), fmt.Sprintf("%s -l -J -o PATH,LABEL", r.lsblk()))
+	if err == nil {
+		blk := &Lsblk{}
+		if err = json.Unmarshal([]byte(out), blk); err == nil {
+			for _, d := range blk.BlockDevices {
+				if _, seen := labels[d.Label]; d.Label != "" && !seen {
+					labels[d.Label] = d.Path
+				}
+			}
+			return labels, nil
+		}
+	}
+
+	fs := r.filesystem()
+	entries, dirErr := fs.ReadDir(labelsDir)
+	if dirErr != nil {
+		return labels, fmt.Errorf("could not list the labels with lsblk (%s) nor from %s: %w", err, labelsDir, dirErr)
+	}
+	for _, e := range entries {
+		link := filepath.Join(labelsDir, e.Name())
+		device, err := CanonicalDeviceWithVFS(fs, link)
+		if err != nil {
+			device = link
+		}
+		labels[unescapeUdev(e.Name())] = device
+	}
+	return labels, nil
+}
+
+// unescapeUdev decodes the \xHH escapes udev uses in link names for characters like spaces and slashes
+func unescapeUdev(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("ValidateLabel", func() {
+	DescribeTable("accepts valid labels",
+		func(label string) {
+			Expect(ValidateLabel(label)).To(Succeed())
+		},
+		Entry("the default labels", "COS_PERSISTENT"),
+		Entry("with dots and dashes", "data-1.0"),
+		Entry("the longest ext4 label", "ABCDEFGHIJKLMNOP"),
+	)
+
+	DescribeTable("rejects invalid labels",
+		func(label, reason string) {
+			Expect(ValidateLabel(label)).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("empty", "", "empty"),
+		Entry("too long", "COS_PERSISTENT_DATA", "longer than 16"),
+		Entry("with spaces", "COS OEM", "invalid characters"),
+		Entry("with shell metacharacters", "COS_OEM;reboot", "invalid characters"),
+		Entry("with a path", "../sda", "invalid characters"),
+	)
+})
+
+var _ = Describe("AllLabels", func() {
+	It("lists the labels of every device from lsblk", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			Expect(command).To(Equal("lsblk -l -J -o PATH,LABEL"))
+			return `{"blockdevices": [
+				{"path": "/dev/sda"},
+				{"path": "/dev/sda2", "label": "COS_OEM"},
+				{"path": "/dev/sda5", "label": "COS_PERSISTENT"},
+				{"path": "/dev/sdb1", "label": "COS_PERSISTENT"},
+				{"path": "/dev/sdb2", "label": "old data"}
+			]}`, nil
+		}
+		labels, err := Runtime{}.WithCommandRunner(runner).AllLabels()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(labels).To(Equal(map[string]string{"COS_OEM": "/dev/sda2", "COS_PERSISTENT": "/dev/sda5", "old data": "/dev/sdb2"}))
+	})
+
+	It("runs the configured lsblk", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			Expect(command).To(Equal("/opt/bin/lsblk -l -J -o PATH,LABEL"))
+			return `{"blockdevices": [{"path": "/dev/sda2", "label": "COS_OEM"}]}`, nil
+		}
+		labels, err := Runtime{}.WithCommandRunner(runner).WithToolPaths("/opt/bin/lsblk", "").AllLabels()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(labels).To(Equal(map[string]string{"COS_OEM": "/dev/sda2"}))
+	})
+
+	It("falls back to the udev links without lsblk", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/dev/sda2":                         "",
+			"/dev/sdb2":                         "",
+			"/dev/disk/by-label/COS_OEM":        &vfst.Symlink{Target: "../../sda2"},
+			"/dev/disk/by-label/old\\x20data":   &vfst.Symlink{Target: "../../sdb2"},
+			"/dev/disk/by-label/COS_PERSISTENT": &vfst.Symlink{Target: "../../mapper/gone"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		runner := func(_ context.Context, _ string) (string, error) {
+			return "sh: lsblk: not found", errors.New("exit status 127")
+		}
+		labels, err := Runtime{}.WithFS(fs).WithCommandRunner(runner).AllLabels()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(labels).To(HaveKeyWithValue("COS_OEM", "/dev/sda2"))
+		Expect(labels).To(HaveKeyWithValue("old data", "/dev/sdb2"))
+		Expect(labels).To(HaveKey("COS_PERSISTENT"))
+	})
+
+	It("fails when no labels can be read", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/dev/sda": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		runner := func(_ context.Context, _ string) (string, error) {
+			return "", errors.New("exit status 127")
+		}
+		_, err = Runtime{}.WithFS(fs).WithCommandRunner(runner).AllLabels()
+		Expect(err).To(HaveOccurred())
+	})
+})
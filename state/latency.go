@@ -0,0 +1,36 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// latencyProbeFile is written under the mountpoint to time the disk, and removed right after
+const latencyProbeFile = ".kairos-io-latency"
+
+// measureIOLatency times writing a small file under the mountpoint of the partition and syncing it, so it goes
+// all the way to the disk instead of being served from the page cache like a read could be.
+// Partitions that are not mounted, or that can't be written, like read-only ones, are left without latency.
+func measureIOLatency(fs vfs.FS, p *PartitionState) {
+	if !p.Mounted || p.MountPoint == "" {
+		return
+	}
+	path := filepath.Join(p.MountPoint, latencyProbeFile)
+	start := time.Now()
+	f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return
+	}
+	defer fs.Remove(path)
+	_, err = f.Write([]byte("kairos"))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err != nil || closeErr != nil {
+		return
+	}
+	p.IOLatency = time.Since(start)
+}
@@ -0,0 +1,32 @@
+package state
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("io latency", func() {
+	It("measures the latency of mounted partitions only", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/usr/local/.kairos/sentinel": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		p := &PartitionState{Found: true, Mounted: true, MountPoint: "/usr/local"}
+		measureIOLatency(fs, p)
+		Expect(p.IOLatency).To(BeNumerically(">", 0))
+		// the probe file doesn't stay around
+		_, err = fs.Stat("/usr/local/" + latencyProbeFile)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		p = &PartitionState{Found: true}
+		measureIOLatency(fs, p)
+		Expect(p.IOLatency).To(BeZero())
+
+		p = &PartitionState{Found: true, Mounted: true, MountPoint: "/missing"}
+		measureIOLatency(fs, p)
+		Expect(p.IOLatency).To(BeZero())
+	})
+})
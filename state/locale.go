@@ -0,0 +1,39 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// DetectTimezoneWithVFS returns the timezone of the system, like Europe/Berlin, from /etc/timezone or where the
+// /etc/localtime symlink points to. It's empty if neither can be read.
+func DetectTimezoneWithVFS(fs vfs.FS) string {
+	if dat, err := fs.ReadFile("/etc/timezone"); err == nil {
+		if tz := strings.TrimSpace(string(dat)); tz != "" {
+			return tz
+		}
+	}
+	target, err := fs.Readlink("/etc/localtime")
+	if err != nil {
+		return ""
+	}
+	if i := strings.Index(target, "zoneinfo/"); i >= 0 {
+		return target[i+len("zoneinfo/"):]
+	}
+	return ""
+}
+
+// DetectLocaleWithVFS returns the LANG set in /etc/locale.conf, like en_US.UTF-8. It's empty if it can't be read.
+func DetectLocaleWithVFS(fs vfs.FS) string {
+	dat, err := fs.ReadFile("/etc/locale.conf")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if lang, ok := strings.CutPrefix(strings.TrimSpace(line), "LANG="); ok {
+			return strings.Trim(lang, `"'`)
+		}
+	}
+	return ""
+}
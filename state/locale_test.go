@@ -0,0 +1,44 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectTimezoneWithVFS", func() {
+	DescribeTable("detects the timezone",
+		func(files map[string]interface{}, expected string) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectTimezoneWithVFS(fs)).To(Equal(expected))
+		},
+		Entry("from /etc/timezone", map[string]interface{}{
+			"/etc/timezone":  "Europe/Berlin\n",
+			"/etc/localtime": &vfst.Symlink{Target: "../usr/share/zoneinfo/UTC"},
+		}, "Europe/Berlin"),
+		Entry("from the localtime symlink", map[string]interface{}{
+			"/usr/share/zoneinfo/America/New_York": "",
+			"/etc/localtime":                       &vfst.Symlink{Target: "../usr/share/zoneinfo/America/New_York"},
+		}, "America/New_York"),
+		Entry("a localtime copy", map[string]interface{}{"/etc/localtime": "TZif2"}, ""),
+		Entry("nothing", map[string]interface{}{"/etc/os-release": ""}, ""),
+	)
+})
+
+var _ = Describe("DetectLocaleWithVFS", func() {
+	DescribeTable("detects the locale",
+		func(files map[string]interface{}, expected string) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectLocaleWithVFS(fs)).To(Equal(expected))
+		},
+		Entry("plain", map[string]interface{}{"/etc/locale.conf": "LANG=en_US.UTF-8\n"}, "en_US.UTF-8"),
+		Entry("quoted among others", map[string]interface{}{"/etc/locale.conf": "LC_TIME=C\nLANG=\"de_DE.UTF-8\"\n"}, "de_DE.UTF-8"),
+		Entry("without LANG", map[string]interface{}{"/etc/locale.conf": "LC_TIME=C\n"}, ""),
+		Entry("nothing", map[string]interface{}{"/etc/os-release": ""}, ""),
+	)
+})
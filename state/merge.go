@@ -0,0 +1,75 @@
+package state
+
+import "reflect"
+
+// Merge fills in the parts of the runtime that are missing from another, partial, runtime. It's meant to
+// assemble a runtime probed in stages, so nothing that is already populated is overwritten:
+//   - a found partition beats a not found one, and is taken as a whole
+//   - otherwise, non-empty values beat empty ones, Unknown boot states, bootloaders and init systems count as empty
+func (r *Runtime) Merge(other Runtime) {
+	for _, p := range []struct{ dst, src *PartitionState }{
+		{&r.Persistent, &other.Persistent},
+		{&r.Recovery, &other.Recovery},
+		{&r.OEM, &other.OEM},
+		{&r.State, &other.State},
+	} {
+		if !p.dst.Found && p.src.Found {
+			*p.dst = *p.src
+		}
+	}
+	for label, p := range other.Extra {
+		if current, ok := r.Extra[label]; ok && !current.Found && p.Found {
+			r.Extra[label] = p
+		}
+	}
+	if r.BootState == Unknown && other.BootState != "" {
+		r.BootState = other.BootState
+	}
+	if r.Bootloader == BootloaderUnknown && other.Bootloader != "" {
+		r.Bootloader = other.Bootloader
+	}
+	if r.Init == InitUnknown && other.Init != "" {
+		r.Init = other.Init
+	}
+	fillZero(reflect.ValueOf(r).Elem(), reflect.ValueOf(other))
+}
+
+// fillZero recursively sets the zero fields of dst to their value in src. Structs with no exported fields, like
+// time.Time, can't be merged field by field and are taken as a whole.
+func fillZero(dst, src reflect.Value) {
+	switch {
+	case dst.Kind() == reflect.Struct && hasExportedFields(dst.Type()):
+		for i := 0; i < dst.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				fillZero(dst.Field(i), src.Field(i))
+			}
+		}
+	case dst.Kind() == reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		}
+		iter := src.MapRange()
+		for iter.Next() {
+			if !dst.MapIndex(iter.Key()).IsValid() {
+				dst.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+	default:
+		if dst.IsZero() && !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// hasExportedFields returns whether any of the fields of the struct type is exported
+func hasExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
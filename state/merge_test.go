@@ -0,0 +1,57 @@
+package state_test
+
+import (
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Merge", func() {
+	It("fills the parts probed in a later stage", func() {
+		early := Runtime{BootState: Active, UUID: "uuid", Timings: map[string]time.Duration{"kairos": time.Second}}
+		late := Runtime{
+			BootState:  Unknown,
+			Persistent: PartitionState{Found: true, Mounted: true, Name: "/dev/sda5", MountPoint: "/usr/local"},
+			Kairos:     Kairos{Version: "v2.3.0"},
+			Timings:    map[string]time.Duration{"ghw": time.Second, "kairos": time.Minute},
+		}
+
+		early.Merge(late)
+		Expect(early.BootState).To(Equal(Active))
+		Expect(early.UUID).To(Equal("uuid"))
+		Expect(early.Persistent).To(Equal(late.Persistent))
+		Expect(early.Kairos.Version).To(Equal("v2.3.0"))
+		Expect(early.Timings).To(Equal(map[string]time.Duration{"ghw": time.Second, "kairos": time.Second}))
+	})
+
+	It("prefers found partitions", func() {
+		r := Runtime{OEM: PartitionState{Found: false, FilesystemLabel: "COS_OEM"}}
+		r.Merge(Runtime{OEM: PartitionState{Found: true, Name: "/dev/sda2", Type: "ext4"}})
+		Expect(r.OEM).To(Equal(PartitionState{Found: true, Name: "/dev/sda2", Type: "ext4"}))
+	})
+
+	It("doesn't clobber populated fields", func() {
+		r := Runtime{OEM: PartitionState{Found: true, Name: "/dev/sda2"}}
+		r.Merge(Runtime{OEM: PartitionState{Found: true, Name: "/dev/sdb2", Mounted: true, MountPoint: "/oem"}})
+		Expect(r.OEM).To(Equal(PartitionState{Found: true, Name: "/dev/sda2", Mounted: true, MountPoint: "/oem"}))
+	})
+
+	It("replaces unknown boot states", func() {
+		r := Runtime{BootState: Unknown, Bootloader: BootloaderUnknown, Init: InitUnknown}
+		r.Merge(Runtime{BootState: Recovery, Bootloader: BootloaderGrub, Init: InitSystemd})
+		Expect(r.BootState).To(Equal(Recovery))
+		Expect(r.Bootloader).To(Equal(BootloaderGrub))
+		Expect(r.Init).To(Equal(InitSystemd))
+	})
+	It("takes the times it's missing as a whole", func() {
+		boot := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+		r := Runtime{UUID: "uuid"}
+		r.Merge(Runtime{BootTime: boot})
+		Expect(r.BootTime).To(Equal(boot))
+
+		r.Merge(Runtime{BootTime: boot.Add(time.Hour)})
+		Expect(r.BootTime).To(Equal(boot))
+	})
+})
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteOpenMetrics writes the runtime in the OpenMetrics text format, for the node-exporter textfile collector.
+// Partitions are labelled with partition (persistent, oem... or the Extra key), device and label, so the series
+// are the same whoever exposes them.
+func (r Runtime) WriteOpenMetrics(w io.Writer) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintln(b, "# TYPE kairos info")
+	fmt.Fprintln(b, "# HELP kairos Kairos release running on the node.")
+	fmt.Fprintf(b, "kairos_info{flavor=%s,version=%s,bootloader=%s,init=%s} 1\n",
+		metricLabel(r.Kairos.Flavor), metricLabel(r.Kairos.Version), metricLabel(r.Bootloader), metricLabel(r.Init))
+
+	fmt.Fprintln(b, "# TYPE kairos_boot_state stateset")
+	fmt.Fprintln(b, "# HELP kairos_boot_state Boot entry the node booted from.")
+	for _, s := range AllBootStates() {
+		value := 0
+		if r.BootState == s {
+			value = 1
+		}
+		fmt.Fprintf(b, "kairos_boot_state{kairos_boot_state=%s} %d\n", metricLabel(string(s)), value)
+	}
+
+	partitions := map[string]PartitionState{"persistent": r.Persistent, "recovery": r.Recovery, "oem": r.OEM, "state": r.State}
+	for k, p := range r.Extra {
+		partitions[k] = p
+	}
+	keys := []string{}
+	for k, p := range partitions {
+		if p.Found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	labels := func(key string) string {
+		p := partitions[key]
+		return fmt.Sprintf("{partition=%s,device=%s,label=%s}", metricLabel(key), metricLabel(p.Name), metricLabel(p.FilesystemLabel))
+	}
+
+	fmt.Fprintln(b, "# TYPE kairos_partition_size_bytes gauge")
+	fmt.Fprintln(b, "# UNIT kairos_partition_size_bytes bytes")
+	fmt.Fprintln(b, "# HELP kairos_partition_size_bytes Size of the partition.")
+	for _, k := range keys {
+		fmt.Fprintf(b, "kairos_partition_size_bytes%s %d\n", labels(k), partitions[k].SizeBytes)
+	}
+	fmt.Fprintln(b, "# TYPE kairos_partition_mounted gauge")
+	fmt.Fprintln(b, "# HELP kairos_partition_mounted Whether the partition is mounted.")
+	for _, k := range keys {
+		fmt.Fprintf(b, "kairos_partition_mounted%s %d\n", labels(k), boolMetric(partitions[k].Mounted))
+	}
+	fmt.Fprintln(b, "# TYPE kairos_partition_read_only gauge")
+	fmt.Fprintln(b, "# HELP kairos_partition_read_only Whether the partition is mounted read-only.")
+	for _, k := range keys {
+		fmt.Fprintf(b, "kairos_partition_read_only%s %d\n", labels(k), boolMetric(partitions[k].IsReadOnly))
+	}
+
+	// Usage is only measured for some partitions, leave the others out rather than reporting them as empty
+	measured := []string{}
+	for _, k := range keys {
+		if partitions[k].UsedBytes != 0 || partitions[k].FreeBytes != 0 {
+			measured = append(measured, k)
+		}
+	}
+	fmt.Fprintln(b, "# TYPE kairos_partition_used_bytes gauge")
+	fmt.Fprintln(b, "# UNIT kairos_partition_used_bytes bytes")
+	fmt.Fprintln(b, "# HELP kairos_partition_used_bytes Space used on the filesystem of the partition.")
+	for _, k := range measured {
+		fmt.Fprintf(b, "kairos_partition_used_bytes%s %d\n", labels(k), partitions[k].UsedBytes)
+	}
+	fmt.Fprintln(b, "# TYPE kairos_partition_free_bytes gauge")
+	fmt.Fprintln(b, "# UNIT kairos_partition_free_bytes bytes")
+	fmt.Fprintln(b, "# HELP kairos_partition_free_bytes Space left on the filesystem of the partition.")
+	for _, k := range measured {
+		fmt.Fprintf(b, "kairos_partition_free_bytes%s %d\n", labels(k), partitions[k].FreeBytes)
+	}
+
+	fmt.Fprintln(b, "# EOF")
+	return b.Flush()
+}
+
+// metricLabel quotes a label value, escaping what OpenMetrics requires
+func metricLabel(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
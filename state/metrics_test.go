@@ -0,0 +1,56 @@
+package state_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+var _ = Describe("WriteOpenMetrics", func() {
+	r := Runtime{
+		BootState:  Active,
+		Bootloader: "grub",
+		Init:       "systemd",
+		Kairos:     Kairos{Flavor: "opensuse", Version: "v2.4.0"},
+		Persistent: PartitionState{Found: true, Mounted: true, Name: "/dev/sda5", FilesystemLabel: "COS_PERSISTENT", SizeBytes: 1024},
+		OEM:        PartitionState{Found: true, Mounted: true, IsReadOnly: true, Name: "/dev/sda2", FilesystemLabel: "COS_OEM", SizeBytes: 64},
+		Extra: map[string]PartitionState{
+			EFIPartitionKey: {Found: true, Mounted: true, Name: "/dev/sda1", FilesystemLabel: "COS_GRUB", SizeBytes: 32, UsedBytes: 8, FreeBytes: 24},
+		},
+	}
+
+	It("writes the release, boot state and partitions", func() {
+		var buf bytes.Buffer
+		Expect(r.WriteOpenMetrics(&buf)).To(Succeed())
+		out := buf.String()
+		Expect(out).To(ContainSubstring(`kairos_info{flavor="opensuse",version="v2.4.0",bootloader="grub",init="systemd"} 1` + "\n"))
+		Expect(out).To(ContainSubstring(`kairos_boot_state{kairos_boot_state="active_boot"} 1` + "\n"))
+		Expect(out).To(ContainSubstring(`kairos_boot_state{kairos_boot_state="recovery_boot"} 0` + "\n"))
+		Expect(out).To(ContainSubstring(`kairos_partition_size_bytes{partition="persistent",device="/dev/sda5",label="COS_PERSISTENT"} 1024` + "\n"))
+		Expect(out).To(ContainSubstring(`kairos_partition_read_only{partition="oem",device="/dev/sda2",label="COS_OEM"} 1` + "\n"))
+		Expect(out).To(ContainSubstring(`kairos_partition_free_bytes{partition="efi",device="/dev/sda1",label="COS_GRUB"} 24` + "\n"))
+		Expect(out).ToNot(ContainSubstring(`partition="recovery"`))
+		Expect(out).ToNot(ContainSubstring(`kairos_partition_free_bytes{partition="persistent"`))
+		Expect(strings.HasSuffix(out, "# EOF\n")).To(BeTrue())
+	})
+
+	It("escapes the label values", func() {
+		var buf bytes.Buffer
+		Expect(Runtime{Kairos: Kairos{Flavor: `a"b\c`}}.WriteOpenMetrics(&buf)).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring(`flavor="a\"b\\c"`))
+	})
+
+	It("returns the errors of the writer", func() {
+		Expect(r.WriteOpenMetrics(failingWriter{})).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,249 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+const (
+	PropagationShared     = "shared"
+	PropagationSlave      = "slave"
+	PropagationPrivate    = "private"
+	PropagationUnbindable = "unbindable"
+)
+
+// MountInfo is a single entry of /proc/self/mountinfo
+// See https://www.kernel.org/doc/Documentation/filesystems/proc.txt for the format
+type MountInfo struct {
+	MountID      int
+	ParentID     int
+	MajorMinor   string
+	Root         string
+	MountPoint   string
+	Options      string
+	Optional     []string
+	FSType       string
+	Source       string
+	SuperOptions string
+}
+
+// Propagation returns the propagation type of the mount based on its optional fields
+func (m MountInfo) Propagation() string {
+	var shared, slave, unbindable bool
+	for _, o := range m.Optional {
+		switch {
+		case strings.HasPrefix(o, "shared:"):
+			shared = true
+		case strings.HasPrefix(o, "master:"):
+			slave = true
+		case o == "unbindable":
+			unbindable = true
+		}
+	}
+	switch {
+	case shared && slave:
+		// A mount can receive events from its master and also forward them to its peer group
+		return fmt.Sprintf("%s,%s", PropagationShared, PropagationSlave)
+	case shared:
+		return PropagationShared
+	case slave:
+		return PropagationSlave
+	case unbindable:
+		return PropagationUnbindable
+	default:
+		return PropagationPrivate
+	}
+}
+
+// ParseMountInfo parses the contents of a mountinfo file into its entries
+func ParseMountInfo(data []byte) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		// The optional fields are terminated by a single hyphen, find it so we know where the rest starts
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 7 || sep == -1 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", line)
+		}
+		mountID, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("malformed mount id in mountinfo line %q: %w", line, err)
+		}
+		parentID, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed parent id in mountinfo line %q: %w", line, err)
+		}
+		m := MountInfo{
+			MountID:    mountID,
+			ParentID:   parentID,
+			MajorMinor: fields[2],
+			Root:       unescapeMountPath(fields[3]),
+			MountPoint: unescapeMountPath(fields[4]),
+			Options:    fields[5],
+			Optional:   fields[6:sep],
+			FSType:     fields[sep+1],
+			Source:     unescapeMountPath(fields[sep+2]),
+		}
+		if len(fields) > sep+3 {
+			m.SuperOptions = fields[sep+3]
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath reverts the octal escaping the kernel does on spaces, tabs, newlines and backslashes in paths
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readMountInfo reads and parses /proc/self/mountinfo
+func readMountInfo(fs vfs.FS) ([]MountInfo, error) {
+	data, err := fs.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	return ParseMountInfo(data)
+}
+
+// detectPropagation fills the propagation type of the mounted partitions from /proc/self/mountinfo
+// Failing to read the mountinfo is not fatal, the propagation is just left empty
+func detectPropagation(fs vfs.FS, parts ...*PartitionState) {
+	mounts, err := readMountInfo(fs)
+	if err != nil {
+		return
+	}
+	for _, p := range parts {
+		if !p.Mounted {
+			continue
+		}
+		// Later entries are mounted on top of earlier ones, so the last match is the one that is visible
+		for _, m := range mounts {
+			if m.MountPoint == p.MountPoint {
+				p.Propagation = m.Propagation()
+			}
+		}
+	}
+}
+
+// OverlayDirs returns the lower, upper and work dirs of an overlay mount, they are empty for any other filesystem
+func (m MountInfo) OverlayDirs() (lower []string, upper, work string) {
+	if m.FSType != "overlay" {
+		return nil, "", ""
+	}
+	for _, o := range strings.Split(m.SuperOptions, ",") {
+		k, v, _ := strings.Cut(o, "=")
+		switch k {
+		case "lowerdir":
+			for _, l := range strings.Split(v, ":") {
+				lower = append(lower, unescapeMountPath(l))
+			}
+		case "upperdir":
+			upper = unescapeMountPath(v)
+		case "workdir":
+			work = unescapeMountPath(v)
+		}
+	}
+	return lower, upper, work
+}
+
+// BackingDevices returns the block devices behind the mount. Overlays, like the ones making up the immutable root,
+// are followed through their upper and lower dirs down to the mounts holding them, so an overlay on /etc with its
+// upper dir on /usr/local returns the persistent device. Sources that are not devices, like tmpfs, are left out.
+func BackingDevices(mounts []MountInfo, m MountInfo) []string {
+	devices := []string{}
+	seen := map[string]bool{}
+	var walk func(m MountInfo, visited map[int]bool)
+	walk = func(m MountInfo, visited map[int]bool) {
+		visited[m.MountID] = true
+		if m.FSType != "overlay" {
+			if strings.HasPrefix(m.Source, "/dev/") && !seen[m.Source] {
+				seen[m.Source] = true
+				devices = append(devices, m.Source)
+			}
+			return
+		}
+		lower, upper, _ := m.OverlayDirs()
+		dirs := lower
+		if upper != "" {
+			dirs = append([]string{upper}, lower...)
+		}
+		for _, d := range dirs {
+			if holder, ok := mountHolding(mounts, d, visited); ok {
+				walk(holder, visited)
+			}
+		}
+	}
+	walk(m, map[int]bool{})
+	return devices
+}
+
+// overlayOnReadOnly returns whether all the lower dirs of the overlay are on read-only mounts, like the root of
+// Kairos is when it's an overlay on top of the image
+func overlayOnReadOnly(mounts []MountInfo, m MountInfo) bool {
+	lower, _, _ := m.OverlayDirs()
+	if len(lower) == 0 {
+		return false
+	}
+	for _, d := range lower {
+		holder, ok := mountHolding(mounts, d, map[int]bool{m.MountID: true})
+		if !ok || !hasOption(holder.Options, "ro") {
+			return false
+		}
+	}
+	return true
+}
+
+// mountHolding returns the mount the path lives on, skipping the excluded ones. Among mounts on the same mountpoint
+// the last one wins, as it's mounted on top of the others.
+func mountHolding(mounts []MountInfo, path string, excluded map[int]bool) (MountInfo, bool) {
+	var holder MountInfo
+	found := false
+	for _, m := range mounts {
+		if excluded[m.MountID] || !pathUnder(path, m.MountPoint) {
+			continue
+		}
+		if !found || len(m.MountPoint) >= len(holder.MountPoint) {
+			holder, found = m, true
+		}
+	}
+	return holder, found
+}
+
+// pathUnder returns whether path is dir or inside it
+func pathUnder(path, dir string) bool {
+	if dir == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,228 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+// Captured from a Kairos node booted from the active image
+const kairosMountInfo = `22 1 0:21 / / ro,relatime shared:1 - overlay overlay rw,lowerdir=/run/rootfsbase
+23 22 0:5 / /dev rw,nosuid shared:2 - devtmpfs devtmpfs rw,size=4096k,nr_inodes=1048576,mode=755
+24 22 0:22 / /proc rw,nosuid,nodev,noexec,relatime shared:5 - proc proc rw
+25 22 0:23 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw
+40 22 8:4 / /oem rw,relatime shared:20 - ext4 /dev/sda2 rw
+41 22 8:5 / /usr/local rw,relatime master:21 - ext4 /dev/sda5 rw
+42 22 8:3 / /run/initramfs/cos-state ro,relatime - ext4 /dev/sda3 ro
+43 22 8:6 / /run/cos/recovery ro,relatime shared:30 master:12 - ext4 /dev/sda6 ro
+44 22 8:7 / /mnt/with\040space rw,relatime unbindable - ext4 /dev/sda7 rw
+`
+
+// Captured from a Kairos node with the immutable root, trimmed down to the overlays and what backs them
+const overlayMountInfo = `1 0 0:30 / / rw,relatime shared:1 - overlay overlay rw,lowerdir=/run/rootfsbase,upperdir=/run/overlay/upper,workdir=/run/overlay/work
+25 1 0:25 / /run rw,nosuid,nodev shared:12 - tmpfs tmpfs rw,mode=755
+30 25 7:0 / /run/rootfsbase ro,relatime shared:13 - ext2 /dev/loop0 ro
+40 1 8:5 / /usr/local rw,relatime shared:21 - ext4 /dev/sda5 rw
+41 1 0:40 / /etc rw,relatime shared:22 - overlay overlay rw,lowerdir=/etc,upperdir=/usr/local/.state/etc.bind/upper,workdir=/usr/local/.state/etc.bind/work
+42 1 0:41 / /var/lib rw,relatime shared:23 - overlay overlay rw,lowerdir=/var/lib:/run/rootfsbase/var/lib,upperdir=/usr/local/.state/var-lib.bind/upper,workdir=/usr/local/.state/var-lib.bind/work
+`
+
+var _ = Describe("MountInfo", func() {
+	Describe("ParseMountInfo", func() {
+		It("parses all the entries", func() {
+			mounts, err := ParseMountInfo([]byte(kairosMountInfo))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mounts).To(HaveLen(9))
+			Expect(mounts[4]).To(Equal(MountInfo{
+				MountID:      40,
+				ParentID:     22,
+				MajorMinor:   "8:4",
+				Root:         "/",
+				MountPoint:   "/oem",
+				Options:      "rw,relatime",
+				Optional:     []string{"shared:20"},
+				FSType:       "ext4",
+				Source:       "/dev/sda2",
+				SuperOptions: "rw",
+			}))
+		})
+
+		It("unescapes paths", func() {
+			mounts, err := ParseMountInfo([]byte(kairosMountInfo))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mounts[8].MountPoint).To(Equal("/mnt/with space"))
+		})
+
+		It("fails on malformed lines", func() {
+			_, err := ParseMountInfo([]byte("40 22 8:4 / /oem rw,relatime shared:20 ext4 /dev/sda2 rw\n"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Propagation", func() {
+		var mounts []MountInfo
+
+		BeforeEach(func() {
+			var err error
+			mounts, err = ParseMountInfo([]byte(kairosMountInfo))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("detects shared mounts", func() {
+			Expect(mounts[4].Propagation()).To(Equal(PropagationShared))
+		})
+
+		It("detects slave mounts", func() {
+			Expect(mounts[5].Propagation()).To(Equal(PropagationSlave))
+		})
+
+		It("detects private mounts", func() {
+			Expect(mounts[6].Propagation()).To(Equal(PropagationPrivate))
+		})
+
+		It("detects mounts that are both shared and slave", func() {
+			Expect(mounts[7].Propagation()).To(Equal("shared,slave"))
+		})
+
+		It("detects unbindable mounts", func() {
+			Expect(mounts[8].Propagation()).To(Equal(PropagationUnbindable))
+		})
+	})
+
+	Describe("Overlays", func() {
+		var mounts []MountInfo
+
+		BeforeEach(func() {
+			var err error
+			mounts, err = ParseMountInfo([]byte(overlayMountInfo))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("parses the overlay dirs", func() {
+			lower, upper, work := mounts[5].OverlayDirs()
+			Expect(lower).To(Equal([]string{"/var/lib", "/run/rootfsbase/var/lib"}))
+			Expect(upper).To(Equal("/usr/local/.state/var-lib.bind/upper"))
+			Expect(work).To(Equal("/usr/local/.state/var-lib.bind/work"))
+
+			lower, upper, work = mounts[3].OverlayDirs()
+			Expect(lower).To(BeEmpty())
+			Expect(upper).To(BeEmpty())
+			Expect(work).To(BeEmpty())
+		})
+
+		It("resolves the root overlay to the image it is based on", func() {
+			Expect(BackingDevices(mounts, mounts[0])).To(Equal([]string{"/dev/loop0"}))
+		})
+
+		It("resolves overlays down to persistent and the root image", func() {
+			Expect(BackingDevices(mounts, mounts[4])).To(Equal([]string{"/dev/sda5", "/dev/loop0"}))
+			Expect(BackingDevices(mounts, mounts[5])).To(Equal([]string{"/dev/sda5", "/dev/loop0"}))
+		})
+
+		It("returns the source of plain mounts", func() {
+			Expect(BackingDevices(mounts, mounts[3])).To(Equal([]string{"/dev/sda5"}))
+			Expect(BackingDevices(mounts, mounts[1])).To(BeEmpty())
+		})
+	})
+
+	Describe("AllMounts", func() {
+		var r Runtime
+		var cleanup func()
+
+		BeforeEach(func() {
+			fs, c, err := vfst.NewTestFS(map[string]interface{}{
+				"/proc/mounts": "proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\n" +
+					"/dev/loop0 / ext2 ro,relatime 0 0\n" +
+					"tmpfs /run tmpfs rw,nosuid,nodev,mode=755 0 0\n" +
+					"/dev/sda5 /usr/local ext4 rw,relatime 0 0\n" +
+					"/dev/sdb1 /mnt/my\\040data xfs rw 0 0\n",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			cleanup = c
+			r = Runtime{}.WithFS(fs)
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("returns the real filesystems", func() {
+			mounts, err := r.AllMounts()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mounts).To(Equal([]PartitionState{
+				{Found: true, Mounted: true, Name: "/dev/loop0", MountPoint: "/", Type: "ext2", IsReadOnly: true},
+				{Found: true, Mounted: true, Name: "/dev/sda5", MountPoint: "/usr/local", Type: "ext4"},
+				{Found: true, Mounted: true, Name: "/dev/sdb1", MountPoint: "/mnt/my data", Type: "xfs"},
+			}))
+		})
+
+		It("includes the virtual filesystems when asked to", func() {
+			mounts, err := r.AllMountsWithVirtual()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mounts).To(HaveLen(5))
+			Expect(mounts[0]).To(Equal(PartitionState{Found: true, Mounted: true, Name: "proc", MountPoint: "/proc", Type: "proc"}))
+		})
+	})
+
+	Describe("overlays", func() {
+		var fs vfs.FS
+		var cleanup func()
+
+		BeforeEach(func() {
+			var err error
+			fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{
+				"/proc/mounts": "overlay / overlay rw,relatime,lowerdir=/run/rootfsbase,upperdir=/run/overlay/upper,workdir=/run/overlay/work 0 0\n" +
+					"tmpfs /run tmpfs rw,nosuid,nodev,mode=755 0 0\n" +
+					"/dev/loop0 /run/rootfsbase ext2 ro,relatime 0 0\n" +
+					"/dev/sda5 /usr/local ext4 rw,relatime 0 0\n" +
+					"overlay /etc overlay rw,relatime,lowerdir=/etc,upperdir=/usr/local/.state/etc.bind/upper,workdir=/usr/local/.state/etc.bind/work 0 0\n",
+				"/proc/self/mountinfo":           overlayMountInfo,
+				"/sys/fs/ext4/sda5/errors_count": "2\n",
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("names them after their backing device", func() {
+			mounts, err := Runtime{}.WithFS(fs).AllMounts()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mounts[0]).To(Equal(PartitionState{Found: true, Mounted: true, Name: "/dev/loop0", MountPoint: "/", Type: "overlay"}))
+			Expect(mounts[3]).To(Equal(PartitionState{Found: true, Mounted: true, Name: "/dev/sda5", MountPoint: "/etc", Type: "overlay"}))
+		})
+
+		It("checks the device below them for remounts", func() {
+			mounts, err := Runtime{}.WithFS(fs).AllMounts()
+			Expect(err).ToNot(HaveOccurred())
+			etc := mounts[3]
+			etc.IsReadOnly = true
+			Expect(DetectRemountedReadOnlyWithVFS(fs, etc)).To(BeTrue())
+		})
+
+		It("takes a root overlay on top of a read-only image as immutable", func() {
+			Expect(DetectImmutableWithVFS(fs)).To(BeTrue())
+		})
+	})
+
+	DescribeTable("DetectImmutableWithVFS",
+		func(mounts string, expected bool) {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/mounts": mounts})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectImmutableWithVFS(fs)).To(Equal(expected))
+		},
+		Entry("an immutable root", "/dev/loop0 / ext2 ro,relatime 0 0\n"+
+			"tmpfs /run/overlay tmpfs rw,nosuid,nodev 0 0\n"+
+			"overlay /etc overlay rw,lowerdir=/etc,upperdir=/run/overlay/etc/upper,workdir=/run/overlay/etc/work 0 0\n", true),
+		Entry("a read-only root", "/dev/sda1 / ext4 ro,relatime 0 0\n", false),
+		Entry("a writable root with overlays", "/dev/sda1 / ext4 rw,relatime 0 0\n"+
+			"overlay /var/lib/docker/overlay2/x/merged overlay rw,lowerdir=/a,upperdir=/b,workdir=/c 0 0\n", false),
+		Entry("a root remounted read-write", "/dev/loop0 / ext2 ro,relatime 0 0\n"+
+			"/dev/loop0 / ext2 rw,relatime 0 0\n"+
+			"overlay /etc overlay rw,lowerdir=/etc,upperdir=/run/overlay/etc/upper,workdir=/run/overlay/etc/work 0 0\n", false),
+	)
+})
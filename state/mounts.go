@@ -0,0 +1,135 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// virtualFilesystems are the filesystems AllMounts leaves out, as they are not backed by any storage
+var virtualFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true, "configfs": true,
+	"debugfs": true, "devpts": true, "devtmpfs": true, "efivarfs": true, "fusectl": true, "hugetlbfs": true,
+	"mqueue": true, "nsfs": true, "proc": true, "pstore": true, "ramfs": true, "rpc_pipefs": true,
+	"securityfs": true, "selinuxfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+}
+
+// AllMounts returns every filesystem mounted from /proc/mounts, not only the Kairos ones, as partitions. Virtual
+// filesystems like proc, sysfs or tmpfs are left out, AllMountsWithVirtual includes them.
+// Overlays are named after the device they write to, or the one below them if they have no upper dir, as found by
+// BackingDevices. They keep the overlay name when that can't be told.
+func (r Runtime) AllMounts() ([]PartitionState, error) {
+	return r.allMounts(false)
+}
+
+// AllMountsWithVirtual is like AllMounts but also returns the virtual filesystems
+func (r Runtime) AllMountsWithVirtual() ([]PartitionState, error) {
+	return r.allMounts(true)
+}
+
+func (r Runtime) allMounts(includeVirtual bool) ([]PartitionState, error) {
+	dat, err := r.filesystem().ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	mounts := []PartitionState{}
+	overlays := []int{}
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		// /dev/sda5 /usr/local ext4 rw,relatime 0 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || (!includeVirtual && virtualFilesystems[fields[2]]) {
+			continue
+		}
+		readOnly := false
+		for _, o := range strings.Split(fields[3], ",") {
+			if o == "ro" {
+				readOnly = true
+			}
+		}
+		if fields[2] == "overlay" {
+			overlays = append(overlays, len(mounts))
+		}
+		mounts = append(mounts, PartitionState{
+			Found:      true,
+			Mounted:    true,
+			Name:       unescapeMountPath(fields[0]),
+			MountPoint: unescapeMountPath(fields[1]),
+			Type:       fields[2],
+			IsReadOnly: readOnly,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(overlays) > 0 {
+		resolveOverlays(r.filesystem(), mounts, overlays)
+	}
+	return mounts, nil
+}
+
+// resolveOverlays names the overlay mounts at the given indexes after their backing device. Both /proc/mounts and
+// mountinfo list the mounts in the same order, so the overlays are paired by their position among overlays.
+func resolveOverlays(fs vfs.FS, mounts []PartitionState, overlays []int) {
+	infos, err := readMountInfo(fs)
+	if err != nil {
+		return
+	}
+	overlayInfos := []MountInfo{}
+	for _, m := range infos {
+		if m.FSType == "overlay" {
+			overlayInfos = append(overlayInfos, m)
+		}
+	}
+	for i, idx := range overlays {
+		if i >= len(overlayInfos) || overlayInfos[i].MountPoint != mounts[idx].MountPoint {
+			return
+		}
+		if devices := BackingDevices(infos, overlayInfos[i]); len(devices) > 0 {
+			mounts[idx].Name = devices[0]
+		}
+	}
+}
+
+// DetectImmutableWithVFS returns whether the system runs immutable, like Kairos does by default: a read-only root
+// with overlays on top of it for the paths that need to be written to, like /etc. A root that is itself an overlay
+// counts as read-only when all its lower dirs are on read-only mounts, as only its upper dir can be written to then.
+// A read-only root alone is not immutable, nothing could be written anywhere. It uses a vfs so it can be used for
+// tests as well.
+func DetectImmutableWithVFS(fs vfs.FS) bool {
+	mounts, err := Runtime{}.WithFS(fs).AllMountsWithVirtual()
+	if err != nil {
+		return false
+	}
+	rootReadOnly, overlays := false, false
+	for _, m := range mounts {
+		switch {
+		// Mounts can be stacked on /, the last one is the one in use
+		case m.MountPoint == "/":
+			rootReadOnly = m.IsReadOnly
+			if m.Type == "overlay" && !rootReadOnly {
+				rootReadOnly = rootOverlayOnReadOnly(fs)
+			}
+		case m.Type == "overlay":
+			overlays = true
+		}
+	}
+	return rootReadOnly && overlays
+}
+
+// rootOverlayOnReadOnly returns whether the overlay mounted on / is on top of read-only mounts only
+func rootOverlayOnReadOnly(fs vfs.FS) bool {
+	infos, err := readMountInfo(fs)
+	if err != nil {
+		return false
+	}
+	root, found := MountInfo{}, false
+	for _, m := range infos {
+		if m.MountPoint == "/" {
+			root, found = m, true
+		}
+	}
+	return found && overlayOnReadOnly(infos, root)
+}
@@ -0,0 +1,167 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// NetworkInterface is an interface with the addresses it got, link-local ones are left out unless asked for
+type NetworkInterface struct {
+	Name string   `yaml:"name" json:"name"`
+	MAC  string   `yaml:"mac,omitempty" json:"mac,omitempty"`
+	IPv4 []string `yaml:"ipv4,omitempty" json:"ipv4,omitempty"`
+	IPv6 []string `yaml:"ipv6,omitempty" json:"ipv6,omitempty"`
+	// Kind is set for the interfaces aggregating or tagging others, to one of bond, bridge or vlan
+	Kind string `yaml:"kind,omitempty" json:"kind,omitempty"`
+	// Members are the interfaces enslaved to a bond or bridge
+	Members []string `yaml:"members,omitempty" json:"members,omitempty"`
+	// Master is the bond or bridge the interface is enslaved to
+	Master string `yaml:"master,omitempty" json:"master,omitempty"`
+	// Parent and VLANID are the interface a vlan is on and its tag
+	Parent string `yaml:"parent,omitempty" json:"parent,omitempty"`
+	VLANID int    `yaml:"vlan_id,omitempty" json:"vlan_id,omitempty"`
+}
+
+const (
+	InterfaceBond   = "bond"
+	InterfaceBridge = "bridge"
+	InterfaceVLAN   = "vlan"
+)
+
+type NetworkState struct {
+	Interfaces []NetworkInterface `yaml:"interfaces,omitempty" json:"interfaces,omitempty"`
+	// DefaultInterface is the interface of the default route, IPv4 first and then IPv6
+	DefaultInterface string `yaml:"default_interface,omitempty" json:"default_interface,omitempty"`
+}
+
+// FilterAddresses splits the addresses of an interface into IPv4 and IPv6 ones. Link-local addresses (169.254.0.0/16
+// and fe80::/10) are only kept if includeLinkLocal is set, as every interface has one and they are just noise
+// in a report. Loopback addresses are always left out.
+func FilterAddresses(addrs []net.Addr, includeLinkLocal bool) (ipv4, ipv6 []string) {
+	for _, a := range addrs {
+		var ip net.IP
+		switch v := a.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		default:
+			continue
+		}
+		if ip.IsLoopback() || (!includeLinkLocal && ip.IsLinkLocalUnicast()) {
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, ip.String())
+		} else {
+			ipv6 = append(ipv6, ip.String())
+		}
+	}
+	return ipv4, ipv6
+}
+
+// DefaultRouteInterfaceWithVFS returns the interface of the default route from the kernel routing tables using a
+// vfs so it can be used for tests as well. The IPv4 route is preferred, it's empty if there is no default route.
+func DefaultRouteInterfaceWithVFS(fs vfs.FS) string {
+	// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+	if dat, err := fs.ReadFile("/proc/net/route"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(dat))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 8 && fields[1] == "00000000" && fields[7] == "00000000" {
+				return fields[0]
+			}
+		}
+	}
+	// Destination PrefixLen Source SourcePrefixLen NextHop Metric RefCnt Use Flags Iface
+	if dat, err := fs.ReadFile("/proc/net/ipv6_route"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(dat))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 10 && fields[0] == strings.Repeat("0", 32) && fields[1] == "00" && fields[9] != "lo" {
+				return fields[9]
+			}
+		}
+	}
+	return ""
+}
+
+// InterfaceTopologyWithVFS fills in how the interface relates to the others, whether it's a bond, a bridge, a
+// vlan or a member of one, from sysfs and /proc/net/vlan using a vfs so it can be used for tests as well.
+// Plain interfaces are returned as they are.
+func InterfaceTopologyWithVFS(fs vfs.FS, iface NetworkInterface) NetworkInterface {
+	dir := filepath.Join("/sys/class/net", iface.Name)
+	if master, err := fs.Readlink(filepath.Join(dir, "master")); err == nil {
+		iface.Master = filepath.Base(master)
+	}
+	switch {
+	case exists(fs, filepath.Join(dir, "bonding")):
+		iface.Kind = InterfaceBond
+		if dat, err := fs.ReadFile(filepath.Join(dir, "bonding", "slaves")); err == nil {
+			iface.Members = strings.Fields(string(dat))
+		}
+	case exists(fs, filepath.Join(dir, "bridge")):
+		iface.Kind = InterfaceBridge
+		if entries, err := fs.ReadDir(filepath.Join(dir, "brif")); err == nil {
+			for _, e := range entries {
+				iface.Members = append(iface.Members, e.Name())
+			}
+		}
+	default:
+		// eth0.100  VID: 100	 REF : 1	... followed by a "Device: eth0" line
+		dat, err := fs.ReadFile(filepath.Join("/proc/net/vlan", iface.Name))
+		if err != nil {
+			break
+		}
+		iface.Kind = InterfaceVLAN
+		scanner := bufio.NewScanner(bytes.NewReader(dat))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			for i := 0; i+1 < len(fields); i++ {
+				switch fields[i] {
+				case "VID:":
+					iface.VLANID, _ = strconv.Atoi(fields[i+1])
+				case "Device:":
+					iface.Parent = fields[i+1]
+				}
+			}
+		}
+	}
+	return iface
+}
+
+// detectNetwork lists the interfaces that are up, except loopback, with their addresses and topology
+func detectNetwork(r *Runtime, o *Options) {
+	r.Network.DefaultInterface = DefaultRouteInterfaceWithVFS(o.FS)
+	for _, iface := range o.interfaces(o.IncludeLinkLocal) {
+		r.Network.Interfaces = append(r.Network.Interfaces, InterfaceTopologyWithVFS(o.FS, iface))
+	}
+}
+
+// hostInterfaces lists the interfaces of the running system that are up, except loopback, with their addresses
+func hostInterfaces(includeLinkLocal bool) []NetworkInterface {
+	interfaces := []NetworkInterface{}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return interfaces
+	}
+	for _, i := range ifaces {
+		if i.Flags&net.FlagLoopback != 0 || i.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		iface := NetworkInterface{Name: i.Name, MAC: i.HardwareAddr.String()}
+		iface.IPv4, iface.IPv6 = FilterAddresses(addrs, includeLinkLocal)
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
@@ -0,0 +1,141 @@
+package state_test
+
+import (
+	"net"
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Network", func() {
+	Describe("FilterAddresses", func() {
+		var addrs []net.Addr
+
+		BeforeEach(func() {
+			addrs = []net.Addr{}
+			for _, cidr := range []string{
+				"192.168.1.10/24",
+				"169.254.12.1/16",
+				"2001:db8::10/64",
+				"fe80::5054:ff:fe12:3456/64",
+				"fd00::1/8",
+				"127.0.0.1/8",
+				"::1/128",
+			} {
+				ip, ipnet, err := net.ParseCIDR(cidr)
+				Expect(err).ToNot(HaveOccurred())
+				ipnet.IP = ip
+				addrs = append(addrs, ipnet)
+			}
+		})
+
+		It("leaves link-local addresses out by default", func() {
+			ipv4, ipv6 := FilterAddresses(addrs, false)
+			Expect(ipv4).To(Equal([]string{"192.168.1.10"}))
+			Expect(ipv6).To(Equal([]string{"2001:db8::10", "fd00::1"}))
+		})
+
+		It("keeps link-local addresses when asked to", func() {
+			ipv4, ipv6 := FilterAddresses(addrs, true)
+			Expect(ipv4).To(Equal([]string{"192.168.1.10", "169.254.12.1"}))
+			Expect(ipv6).To(Equal([]string{"2001:db8::10", "fe80::5054:ff:fe12:3456", "fd00::1"}))
+		})
+
+		It("handles interfaces with only link-local addresses", func() {
+			ipv4, ipv6 := FilterAddresses(addrs[3:4], false)
+			Expect(ipv4).To(BeEmpty())
+			Expect(ipv6).To(BeEmpty())
+		})
+	})
+
+	Describe("DefaultRouteInterfaceWithVFS", func() {
+		ipv6Routes := `00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003   eth1
+fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001   eth1
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`
+		detect := func(files map[string]interface{}) string {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			return DefaultRouteInterfaceWithVFS(fs)
+		}
+
+		It("prefers the IPv4 default route", func() {
+			Expect(detect(map[string]interface{}{
+				"/proc/net/route": "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+					"eth0\t0001A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n" +
+					"eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n",
+				"/proc/net/ipv6_route": ipv6Routes,
+			})).To(Equal("eth0"))
+		})
+
+		It("falls back to the IPv6 default route", func() {
+			Expect(detect(map[string]interface{}{
+				"/proc/net/route":      "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n",
+				"/proc/net/ipv6_route": ipv6Routes,
+			})).To(Equal("eth1"))
+		})
+
+		It("is empty without a default route", func() {
+			Expect(detect(map[string]interface{}{"/proc/net/ipv6_route": ipv6Routes[strings.Index(ipv6Routes, "\n")+1:]})).To(BeEmpty())
+		})
+	})
+
+	Describe("InterfaceTopologyWithVFS", func() {
+		var fs vfs.FS
+		var cleanup func()
+
+		BeforeEach(func() {
+			var err error
+			fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{
+				"/sys/devices/virtual/net/bond0/bonding/slaves": "eth0 eth1\n",
+				"/sys/devices/virtual/net/br0/bridge/stp_state": "0\n",
+				"/sys/devices/virtual/net/br0/brif/bond0.100":   &vfst.Symlink{Target: "../../bond0.100/brport"},
+				"/sys/devices/pci0000:00/net/eth0/master":       &vfst.Symlink{Target: "../../../virtual/net/bond0"},
+				"/sys/devices/pci0000:00/net/eth2/address":      "52:54:00:12:34:58\n",
+				"/sys/class/net/bond0":                          &vfst.Symlink{Target: "../../devices/virtual/net/bond0"},
+				"/sys/class/net/br0":                            &vfst.Symlink{Target: "../../devices/virtual/net/br0"},
+				"/sys/class/net/eth0":                           &vfst.Symlink{Target: "../../devices/pci0000:00/net/eth0"},
+				"/sys/class/net/eth2":                           &vfst.Symlink{Target: "../../devices/pci0000:00/net/eth2"},
+				"/proc/net/vlan/bond0.100":                      "bond0.100  VID: 100\t REF : 1\tREGISTERED\nDevice: bond0\nINGRESS priority mappings: 0:0\n",
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("lists the members of bonds", func() {
+			Expect(InterfaceTopologyWithVFS(fs, NetworkInterface{Name: "bond0"})).To(Equal(NetworkInterface{
+				Name: "bond0", Kind: InterfaceBond, Members: []string{"eth0", "eth1"},
+			}))
+		})
+
+		It("lists the members of bridges", func() {
+			Expect(InterfaceTopologyWithVFS(fs, NetworkInterface{Name: "br0"})).To(Equal(NetworkInterface{
+				Name: "br0", Kind: InterfaceBridge, Members: []string{"bond0.100"},
+			}))
+		})
+
+		It("finds the master of enslaved interfaces", func() {
+			Expect(InterfaceTopologyWithVFS(fs, NetworkInterface{Name: "eth0"})).To(Equal(NetworkInterface{Name: "eth0", Master: "bond0"}))
+		})
+
+		It("finds the parent and tag of vlans", func() {
+			Expect(InterfaceTopologyWithVFS(fs, NetworkInterface{Name: "bond0.100"})).To(Equal(NetworkInterface{
+				Name: "bond0.100", Kind: InterfaceVLAN, Parent: "bond0", VLANID: 100,
+			}))
+		})
+
+		It("leaves plain interfaces alone", func() {
+			Expect(InterfaceTopologyWithVFS(fs, NetworkInterface{Name: "eth2", MAC: "52:54:00:12:34:58"})).To(Equal(NetworkInterface{
+				Name: "eth2", MAC: "52:54:00:12:34:58",
+			}))
+		})
+	})
+})
@@ -0,0 +1,62 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// OEMConfigs lists the yaml config files present on the OEM partition
+func (r Runtime) OEMConfigs() ([]string, error) {
+	if !r.OEM.Mounted {
+		return nil, fmt.Errorf("oem partition is not mounted")
+	}
+	return r.filesystem().Glob(filepath.Join(r.OEM.MountPoint, "*.yaml"))
+}
+
+// AppliedConfigPath is where the agent dumps the merged config it applied during boot
+const AppliedConfigPath = "/run/kairos/config.yaml"
+
+// AppliedConfig returns the merged Kairos config the node booted with. It falls back to the config written to
+// the OEM partition at install time when the boot one is not around, and fails if neither is.
+func (r Runtime) AppliedConfig() ([]byte, error) {
+	paths := []string{AppliedConfigPath}
+	if r.OEM.Mounted && r.OEM.MountPoint != "" {
+		paths = append(paths, filepath.Join(r.OEM.MountPoint, "90_custom.yaml"))
+	}
+	filesystem := r.filesystem()
+	for _, p := range paths {
+		dat, err := filesystem.ReadFile(p)
+		if err == nil {
+			return dat, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no applied config found in %s", strings.Join(paths, ", "))
+}
+
+// RoleFactory marks an OEM partition that carries a factory image to reset from
+const RoleFactory = "factory"
+
+// factoryResetMarkers are the paths, relative to the OEM mountpoint, that flag it as a factory reset source
+var factoryResetMarkers = []string{"factory-reset", ".factory-reset"}
+
+// DetectOEMRoleWithVFS returns the role of the OEM partition using a vfs so it can be used for tests as well.
+// The role is empty unless the partition is mounted and has one of the known markers.
+func DetectOEMRoleWithVFS(fs vfs.FS, oem PartitionState) string {
+	if !oem.Mounted {
+		return ""
+	}
+	for _, m := range factoryResetMarkers {
+		if exists(fs, filepath.Join(oem.MountPoint, m)) {
+			return RoleFactory
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("OEM", func() {
+	Describe("OEMConfigs", func() {
+		It("lists the yaml files on the oem mountpoint", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/90_custom.yaml":  "#cloud-config",
+				"/oem/10_network.yaml": "#cloud-config",
+				"/oem/grubenv":         "",
+				"/oem/subdir/foo.yaml": "#cloud-config",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r := Runtime{OEM: PartitionState{Found: true, Mounted: true, MountPoint: "/oem"}}.WithFS(fs)
+			configs, err := r.OEMConfigs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configs).To(Equal([]string{"/oem/10_network.yaml", "/oem/90_custom.yaml"}))
+		})
+
+		It("fails if oem is not mounted", func() {
+			_, err := Runtime{OEM: PartitionState{Found: true}}.OEMConfigs()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DetectOEMRoleWithVFS", func() {
+		oem := PartitionState{Found: true, Mounted: true, MountPoint: "/oem"}
+
+		It("detects the factory reset marker", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem/factory-reset/image.tar": ""})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectOEMRoleWithVFS(fs, oem)).To(Equal(RoleFactory))
+		})
+
+		It("leaves the role empty without a marker", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem/90_custom.yaml": ""})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectOEMRoleWithVFS(fs, oem)).To(BeEmpty())
+		})
+	})
+
+	Describe("AppliedConfig", func() {
+		oem := PartitionState{Found: true, Mounted: true, MountPoint: "/oem"}
+
+		It("reads the config applied at boot", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				AppliedConfigPath:     "#cloud-config\nhostname: boot",
+				"/oem/90_custom.yaml": "#cloud-config\nhostname: install",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			config, err := Runtime{OEM: oem}.WithFS(fs).AppliedConfig()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(config)).To(Equal("#cloud-config\nhostname: boot"))
+		})
+
+		It("falls back to the config written to oem", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem/90_custom.yaml": "#cloud-config\nhostname: install"})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			config, err := Runtime{OEM: oem}.WithFS(fs).AppliedConfig()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(config)).To(Equal("#cloud-config\nhostname: install"))
+		})
+
+		It("fails if there is no config", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem/10_network.yaml": "#cloud-config"})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			_, err = Runtime{OEM: oem}.WithFS(fs).AppliedConfig()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
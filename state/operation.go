@@ -0,0 +1,44 @@
+package state
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+const (
+	OperationInstall = "install"
+	OperationUpgrade = "upgrade"
+	OperationReset   = "reset"
+)
+
+// operations are checked in this order, an upgrade or reset can run right after an install while its sentinel is still around
+var operations = []string{OperationReset, OperationUpgrade, OperationInstall}
+
+// operationSentinelDirs are where the sentinels of ongoing operations get written, /run for the ones that must not
+// survive a reboot and the kairos dir on persistent, like machine.CreateSentinel does, for the others
+func (r Runtime) operationSentinelDirs() []string {
+	persistent := ExpectedPersistentMountpoint
+	if r.Persistent.Mounted && r.Persistent.MountPoint != "" {
+		persistent = r.Persistent.MountPoint
+	}
+	return []string{"/run/kairos", filepath.Join(persistent, ".kairos")}
+}
+
+// OngoingOperation returns the install, upgrade or reset operation in progress, based on the sentinel files
+// left by Kairos while running them. It returns an empty string when nothing is in progress.
+func (r Runtime) OngoingOperation() (string, error) {
+	filesystem := r.filesystem()
+	for _, op := range operations {
+		for _, dir := range r.operationSentinelDirs() {
+			_, err := filesystem.Stat(filepath.Join(dir, "sentinel_"+op+"_in_progress"))
+			if err == nil {
+				return op, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
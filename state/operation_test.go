@@ -0,0 +1,40 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("OngoingOperation", func() {
+	DescribeTable("detects the operation from its sentinel",
+		func(files map[string]interface{}, expected string) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r := Runtime{Persistent: PartitionState{Found: true, Mounted: true, MountPoint: "/mnt/persistent"}}.WithFS(fs)
+			op, err := r.OngoingOperation()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(op).To(Equal(expected))
+		},
+		Entry("nothing in progress", map[string]interface{}{"/run/kairos/other": ""}, ""),
+		Entry("install", map[string]interface{}{"/run/kairos/sentinel_install_in_progress": ""}, OperationInstall),
+		Entry("upgrade on persistent", map[string]interface{}{"/mnt/persistent/.kairos/sentinel_upgrade_in_progress": ""}, OperationUpgrade),
+		Entry("reset over a leftover install", map[string]interface{}{
+			"/run/kairos/sentinel_install_in_progress": "",
+			"/run/kairos/sentinel_reset_in_progress":   "",
+		}, OperationReset),
+	)
+
+	It("looks in /usr/local when persistent is not mounted", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/usr/local/.kairos/sentinel_upgrade_in_progress": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		op, err := Runtime{}.WithFS(fs).OngoingOperation()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(op).To(Equal(OperationUpgrade))
+	})
+})
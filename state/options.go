@@ -0,0 +1,305 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/block"
+	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// CommandRunner runs a shell command and returns its combined output.
+// It's what the probes use to call external tools, so it can be replaced for tests or remote execution.
+type CommandRunner func(ctx context.Context, command string) (string, error)
+
+// Options tweaks how the runtime is probed by NewRuntimeWithOptions
+type Options struct {
+	// FS is used for all the file reads done while probing
+	FS vfs.FS
+	// Runner is used to call the external tools
+	Runner CommandRunner
+	// FindmntPath and LsblkPath are the binaries called to find the mountpoints and the partitions ghw misses
+	FindmntPath string
+	LsblkPath   string
+	// OSReleasePath is the os-release file the Kairos version and flavor are read from
+	OSReleasePath string
+	// Device restricts the probe to the partitions of a single disk, like /dev/sdb
+	Device string
+	// ChrootPath probes the system rooted there instead of /, all the file reads and ghw are prefixed with it
+	ChrootPath string
+	// Timings records how long each detection phase took into Runtime.Timings
+	Timings bool
+	// DetectionLog records the raw output of the external tools into Runtime.DetectionLog
+	DetectionLog bool
+	// SysinfoEvery makes Watch probe sysinfo only every N ticks, reusing the previous one in between
+	SysinfoEvery int
+	// Debounce makes Watch hold back a change until the runtime has stayed the same for that long
+	Debounce time.Duration
+	// IncludeLinkLocal keeps the link-local addresses of the interfaces in Runtime.Network
+	IncludeLinkLocal bool
+	// StrictBoot makes the probe fail with ErrUnknownBoot when the boot state can't be detected
+	StrictBoot bool
+	// IOLatency times a synced write on the persistent mountpoint into Runtime.Persistent.IOLatency
+	IOLatency bool
+	// NoRoot skips what needs root, sysinfo and the filesystem checks, for agents running unprivileged
+	NoRoot bool
+	// FailFast makes the probe fail on the first lsblk or findmnt error instead of leaving the partition not found
+	FailFast bool
+	// Progress is called as the probe goes through its stages, disks and partitions
+	Progress ProgressFunc
+
+	// checkpoint gets a copy of the runtime after each stage, for NewRuntimeWithDeadline to return
+	checkpoint func(Runtime)
+	// blockDevices, systemInfo and interfaces probe what doesn't go through FS or Runner, the disks with ghw, the
+	// hardware with sysinfo and the network interfaces, they are only replaced in tests
+	blockDevices func(opts ...*ghw.WithOption) (*block.Info, error)
+	systemInfo   func() SystemInfo
+	interfaces   func(includeLinkLocal bool) []NetworkInterface
+}
+
+// ProgressFunc gets the stage being probed and how many of its items are done out of the total
+type ProgressFunc func(stage string, done, total int)
+
+const (
+	defaultFindmnt = "findmnt"
+	defaultLsblk   = "lsblk"
+)
+
+// DefaultOptions returns the options used when probing the running system
+func DefaultOptions() *Options {
+	return &Options{
+		FS:            vfs.OSFS,
+		Runner:        utils.SHContext,
+		OSReleasePath: "/etc/os-release",
+		FindmntPath:   defaultFindmnt,
+		LsblkPath:     defaultLsblk,
+		SysinfoEvery:  1,
+		blockDevices:  block.New,
+		systemInfo:    hostSystemInfo,
+		interfaces:    hostInterfaces,
+	}
+}
+
+type Option func(o *Options) error
+
+func (o *Options) Apply(opts ...Option) error {
+	for _, oo := range opts {
+		if err := oo(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newOptions returns the default options with the given ones applied, ready to probe with
+func newOptions(opts ...Option) (*Options, error) {
+	o := DefaultOptions()
+	if err := o.Apply(opts...); err != nil {
+		return nil, err
+	}
+	if o.ChrootPath != "" {
+		o.FS = vfs.NewPathFS(o.FS, o.ChrootPath)
+	}
+	return o, nil
+}
+
+func WithFS(fs vfs.FS) Option {
+	return func(o *Options) error {
+		o.FS = fs
+		return nil
+	}
+}
+
+func WithCommandRunner(runner CommandRunner) Option {
+	return func(o *Options) error {
+		o.Runner = runner
+		return nil
+	}
+}
+
+// WithOSReleasePath reads the Kairos info from the given os-release, like the one of a mounted upgrade image
+func WithOSReleasePath(path string) Option {
+	return func(o *Options) error {
+		o.OSReleasePath = path
+		return nil
+	}
+}
+
+// WithDevice only looks for the Kairos partitions on the given disk, ignoring the ones on any other disk
+func WithDevice(device string) Option {
+	return func(o *Options) error {
+		o.Device = device
+		return nil
+	}
+}
+
+// onDevice returns whether the device is on the disk the probe is restricted to, or true if it isn't restricted
+func (o *Options) onDevice(device string) bool {
+	if o.Device == "" {
+		return true
+	}
+	target, err := CanonicalDeviceWithVFS(o.FS, o.Device)
+	if err != nil {
+		target = o.Device
+	}
+	for _, d := range parentDisks(o.FS, device) {
+		if d == target {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFindmntPath calls findmnt from the given path, or any binary taking the same arguments
+func WithFindmntPath(path string) Option {
+	return func(o *Options) error {
+		o.FindmntPath = path
+		return nil
+	}
+}
+
+// WithLsblkPath calls lsblk from the given path, or any binary taking the same arguments
+func WithLsblkPath(path string) Option {
+	return func(o *Options) error {
+		o.LsblkPath = path
+		return nil
+	}
+}
+
+// checkTools fails if findmnt or lsblk were set to binaries that can't be found. The default ones are not checked,
+// they are optional as their absence just makes the detection less accurate.
+func (o *Options) checkTools() error {
+	for _, t := range []struct{ path, def string }{{o.FindmntPath, defaultFindmnt}, {o.LsblkPath, defaultLsblk}} {
+		if t.path == t.def {
+			continue
+		}
+		if _, err := exec.LookPath(t.path); err != nil {
+			return fmt.Errorf("configured binary %s not found: %w", t.path, err)
+		}
+	}
+	return nil
+}
+
+// WithChrootPath probes the system mounted at the given path, like when repairing a node from a rescue system
+func WithChrootPath(path string) Option {
+	return func(o *Options) error {
+		o.ChrootPath = path
+		return nil
+	}
+}
+
+// WithTimings records how long each detection phase took into Runtime.Timings, to find what makes a probe slow
+var WithTimings Option = func(o *Options) error {
+	o.Timings = true
+	return nil
+}
+
+// WithLinkLocal keeps link-local addresses, like fe80::, in the network report
+var WithLinkLocal Option = func(o *Options) error {
+	o.IncludeLinkLocal = true
+	return nil
+}
+
+// WithStrictBoot makes the probe fail with ErrUnknownBoot instead of returning an Unknown boot state
+var WithStrictBoot Option = func(o *Options) error {
+	o.StrictBoot = true
+	return nil
+}
+
+// WithIOLatency measures how long a synced write on persistent takes, to flag slow disks before they fail
+var WithIOLatency Option = func(o *Options) error {
+	o.IOLatency = true
+	return nil
+}
+
+// WithNoRoot only probes what unprivileged users can read, the runtime is then flagged as Partial
+var WithNoRoot Option = func(o *Options) error {
+	o.NoRoot = true
+	return nil
+}
+
+// WithFailFast returns the first error of lsblk or findmnt, wrapped with the label of the partition, so a broken
+// tool isn't mistaken for a missing partition
+var WithFailFast Option = func(o *Options) error {
+	o.FailFast = true
+	return nil
+}
+
+// WithDetectionLog keeps the raw output of lsblk, findmnt and the other tools run, keyed by command, so it can
+// be attached to support bundles when a partition is misdetected
+var WithDetectionLog Option = func(o *Options) error {
+	o.DetectionLog = true
+	return nil
+}
+
+// WithProgress reports the progress of the probe to the given func, so slow probes on hosts with many disks
+// can be rendered
+func WithProgress(progress ProgressFunc) Option {
+	return func(o *Options) error {
+		o.Progress = progress
+		return nil
+	}
+}
+
+// WithSysinfoEvery makes Watch only probe sysinfo every n ticks, which is expensive on low power nodes
+func WithSysinfoEvery(n int) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("sysinfo has to be probed at least every tick, got %d", n)
+		}
+		o.SysinfoEvery = n
+		return nil
+	}
+}
+
+// WithDebounce makes Watch coalesce bursts of changes, only emitting once things settle for the given duration
+func WithDebounce(d time.Duration) Option {
+	return func(o *Options) error {
+		o.Debounce = d
+		return nil
+	}
+}
+
+// progress reports the progress of the stage, if anything is listening
+func (o *Options) progress(stage string, done, total int) {
+	if o.Progress != nil {
+		o.Progress(stage, done, total)
+	}
+}
+
+// track starts timing the given phase and returns the func that stops it.
+// It's a noop unless Timings is enabled, so it can be sprinkled around without cost.
+func (o *Options) track(r *Runtime, phase string) func() {
+	if !o.Timings {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		if r.Timings == nil {
+			r.Timings = map[string]time.Duration{}
+		}
+		r.Timings[phase] += time.Since(start)
+	}
+}
+
+// logCommands returns a copy of the options whose runner also records the output of every command into r.DetectionLog.
+// Failed commands get the error appended to their output.
+func (o *Options) logCommands(r *Runtime) *Options {
+	logged := *o
+	logged.Runner = func(ctx context.Context, command string) (string, error) {
+		out, err := o.Runner(ctx, command)
+		if r.DetectionLog == nil {
+			r.DetectionLog = map[string]string{}
+		}
+		r.DetectionLog[command] = out
+		if err != nil {
+			r.DetectionLog[command] += fmt.Sprintf("\n(%s)", err)
+		}
+		return out, err
+	}
+	return &logged
+}
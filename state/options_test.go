@@ -0,0 +1,157 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Options", func() {
+	noTools := WithCommandRunner(func(_ context.Context, _ string) (string, error) {
+		return "", errors.New("exit status 1")
+	})
+	noHost := WithHost(nil, SystemInfo{}, nil)
+
+	Describe("WithStrictBoot", func() {
+		It("fails when the boot state is unknown", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "BOOT_IMAGE=/vmlinuz root=/dev/sda1"})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r, err := NewRuntimeWithOptions(WithFS(fs), WithStrictBoot)
+			Expect(err).To(MatchError(ErrUnknownBoot))
+			Expect(r.BootState).To(Equal(Unknown))
+		})
+
+		It("fails when the cmdline can't be read", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/os-release": ""})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			_, err = NewRuntimeWithOptions(WithFS(fs), WithStrictBoot)
+			Expect(err).To(MatchError(ErrUnknownBoot))
+		})
+	})
+
+	Describe("WithFindmntPath and WithLsblkPath", func() {
+		It("fail when the configured binary doesn't exist", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE"})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			_, err = NewRuntimeWithOptions(WithFS(fs), WithLsblkPath("/opt/tools/lsblk"))
+			Expect(err).To(MatchError(ContainSubstring("/opt/tools/lsblk not found")))
+			_, err = NewRuntimeWithOptions(WithFS(fs), WithFindmntPath("busybox-findmnt"))
+			Expect(err).To(MatchError(ContainSubstring("busybox-findmnt not found")))
+		})
+	})
+
+	Describe("WithOSReleasePath", func() {
+		It("reads the Kairos info from the given os-release", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/proc/cmdline":           "root=LABEL=COS_ACTIVE",
+				"/etc/os-release":         "KAIROS_FLAVOR=alpine\nKAIROS_VERSION=v2.4.0\n",
+				"/run/upgrade/os-release": "KAIROS_FLAVOR=ubuntu\nKAIROS_VERSION=v2.5.0\n",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r, err := NewRuntimeWithOptions(WithFS(fs), noTools, noHost, WithOSReleasePath("/run/upgrade/os-release"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Kairos.Flavor).To(Equal("ubuntu"))
+			Expect(r.Kairos.Version).To(Equal("v2.5.0"))
+		})
+	})
+
+	Describe("WithDevice", func() {
+		It("ignores the partitions on the other disks", func() {
+			files := map[string]interface{}{
+				"/proc/cmdline": "root=LABEL=COS_ACTIVE",
+				"/sys/devices/pci0000:00/block/sdb/sdb1/partition": "1\n",
+				"/sys/class/block/sdb1":                            &vfst.Symlink{Target: "../../devices/pci0000:00/block/sdb/sdb1"},
+			}
+			for k, v := range sysfsDisk {
+				files[k] = v
+			}
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			runner := WithCommandRunner(func(_ context.Context, command string) (string, error) {
+				switch {
+				case strings.Contains(command, "/dev/disk/by-label/COS_OEM "):
+					return `{"blockdevices": [{"path": "/dev/sda1", "fstype": "ext4", "label": "COS_OEM"}]}`, nil
+				case strings.Contains(command, "/dev/disk/by-label/COS_RECOVERY "):
+					return `{"blockdevices": [{"path": "/dev/sdb1", "fstype": "ext4", "label": "COS_RECOVERY"}]}`, nil
+				}
+				return "", errors.New("exit status 1")
+			})
+
+			r, err := NewRuntimeWithOptions(WithFS(fs), runner, noHost)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.OEM.Name).To(Equal("/dev/sda1"))
+			Expect(r.Recovery.Name).To(Equal("/dev/sdb1"))
+
+			r, err = NewRuntimeWithOptions(WithFS(fs), runner, noHost, WithDevice("/dev/sdb"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.OEM.Found).To(BeFalse())
+			Expect(r.Recovery.Name).To(Equal("/dev/sdb1"))
+		})
+	})
+
+	Describe("WithChrootPath", func() {
+		It("reads the files of the system mounted there", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/proc/cmdline":            "root=LABEL=COS_RECOVERY",
+				"/etc/os-release":          "KAIROS_FLAVOR=alpine\nKAIROS_VERSION=v2.4.0\n",
+				"/mnt/node/proc/cmdline":   "root=LABEL=COS_ACTIVE",
+				"/mnt/node/etc/os-release": "KAIROS_FLAVOR=ubuntu\nKAIROS_VERSION=v2.5.0\n",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r, err := NewRuntimeWithOptions(WithFS(fs), noTools, noHost, WithChrootPath("/mnt/node"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.BootState).To(Equal(Active))
+			Expect(r.Kairos.Flavor).To(Equal("ubuntu"))
+			Expect(r.Kairos.Version).To(Equal("v2.5.0"))
+		})
+	})
+
+	Describe("WithTimings", func() {
+		It("only fills the timings when enabled", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE"})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r, err := NewRuntimeWithOptions(WithFS(fs), noTools, noHost)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Timings).To(BeNil())
+
+			r, err = NewRuntimeWithOptions(WithFS(fs), noTools, noHost, WithTimings)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Timings).To(HaveKey("ghw"))
+			Expect(r.Timings).To(HaveKey("lsblk/COS_OEM"))
+		})
+	})
+
+	Describe("WithNoRoot", func() {
+		It("flags the runtime as partial", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE"})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			// sysinfo would report the kernel if it ran
+			host := WithHost(nil, SystemInfo{Kernel: SystemKernel{Release: "6.1.0"}}, nil)
+			r, err := NewRuntimeWithOptions(WithFS(fs), noTools, host, WithNoRoot)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Partial).To(BeTrue())
+			Expect(r.Warnings).To(ContainElement(ContainSubstring("without root")))
+			Expect(r.System).To(BeZero())
+		})
+	})
+})
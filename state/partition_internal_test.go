@@ -0,0 +1,279 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jaypipes/ghw/pkg/block"
+	"github.com/kairos-io/kairos-sdk/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("partition detection", func() {
+	// sleepRunner ignores the command and runs a long sleep instead, so only a cancellation can stop it
+	var sleepRunner CommandRunner = func(ctx context.Context, _ string) (string, error) {
+		return utils.SHContext(ctx, "sleep 10")
+	}
+
+	It("passes the command to the runner", func() {
+		var commands []string
+		runner := func(_ context.Context, command string) (string, error) {
+			commands = append(commands, command)
+			return `{"blockdevices": [{"path": "/dev/sda2", "mountpoint": "/oem", "fstype": "ext4", "label": "COS_OEM"}]}`, nil
+		}
+		part, _ := detectPartitionByLsblk(context.Background(), runner, "lsblk", "COS_OEM")
+		Expect(commands).To(Equal([]string{"lsblk /dev/disk/by-label/COS_OEM -o PATH,FSTYPE,MOUNTPOINT,SIZE,RO,LABEL -J"}))
+		Expect(part).To(Equal(PartitionState{Found: true, Name: "/dev/sda2", Mounted: true, MountPoint: "/oem", Type: "ext4", FilesystemLabel: "COS_OEM"}))
+	})
+
+	It("doesn't call the tools with invalid labels", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			Fail("unexpected command " + command)
+			return "", nil
+		}
+		part, err := detectPartitionByLsblk(context.Background(), runner, "lsblk", "COS_OEM; reboot")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(part).To(Equal(PartitionState{}))
+		part, _ = detectPartitionByFindmnt(context.Background(), runner, "findmnt", &block.Partition{Name: "sda3", FilesystemLabel: "$(reboot)"})
+		Expect(part.Mounted).To(BeFalse())
+	})
+
+	It("records the raw output of the commands when asked to", func() {
+		o := DefaultOptions()
+		o.Runner = func(_ context.Context, command string) (string, error) {
+			if strings.HasPrefix(command, "findmnt") {
+				return "", errors.New("exit status 1")
+			}
+			return `{"blockdevices": [{"path": "/dev/sda2", "label": "COS_OEM"}]}`, nil
+		}
+		r := &Runtime{}
+		logged := o.logCommands(r)
+		detectPartitionByLsblk(context.Background(), logged.Runner, "lsblk", "COS_OEM")
+		detectPartitionByFindmnt(context.Background(), logged.Runner, "findmnt", &block.Partition{Name: "sda3", FilesystemLabel: "COS_STATE"})
+		Expect(r.DetectionLog).To(Equal(map[string]string{
+			"lsblk /dev/disk/by-label/COS_OEM -o PATH,FSTYPE,MOUNTPOINT,SIZE,RO,LABEL -J": `{"blockdevices": [{"path": "/dev/sda2", "label": "COS_OEM"}]}`,
+			"findmnt /dev/disk/by-label/COS_STATE -l -J -o TARGET,FS-OPTIONS":             "\n(exit status 1)",
+		}))
+	})
+
+	It("uses the single findmnt target", func() {
+		runner := func(_ context.Context, _ string) (string, error) {
+			return `{"filesystems": [{"target": "/oem", "fs-options": "rw,relatime"}]}`, nil
+		}
+		part, _ := detectPartitionByFindmnt(context.Background(), runner, "findmnt", &block.Partition{Name: "sda2", FilesystemLabel: "COS_OEM", IsReadOnly: true})
+		Expect(part.MountPoint).To(Equal("/oem"))
+		Expect(part.OtherMountPoints).To(BeEmpty())
+		Expect(part.Mounted).To(BeTrue())
+		Expect(part.IsReadOnly).To(BeFalse())
+	})
+
+	It("picks the root-most of multiple findmnt targets", func() {
+		runner := func(_ context.Context, _ string) (string, error) {
+			return `{"filesystems": [
+				{"target": "/var/lib/rancher", "fs-options": "rw,relatime,subvol=/@/rancher"},
+				{"target": "/usr/local", "fs-options": "ro,relatime,subvol=/@"},
+				{"target": "/home", "fs-options": "rw,relatime,subvol=/@/home"}
+			]}`, nil
+		}
+		part, _ := detectPartitionByFindmnt(context.Background(), runner, "findmnt", &block.Partition{Name: "sda5", FilesystemLabel: "COS_PERSISTENT"})
+		Expect(part.MountPoint).To(Equal("/home"))
+		Expect(part.OtherMountPoints).To(Equal([]string{"/usr/local", "/var/lib/rancher"}))
+		Expect(part.Mounted).To(BeTrue())
+		Expect(part.IsReadOnly).To(BeFalse())
+	})
+
+	It("keeps the findmnt order between equally deep targets of the same length", func() {
+		runner := func(_ context.Context, _ string) (string, error) {
+			return `{"filesystems": [{"target": "/oem", "fs-options": "ro"}, {"target": "/mnt", "fs-options": "rw"}]}`, nil
+		}
+		part, _ := detectPartitionByFindmnt(context.Background(), runner, "findmnt", &block.Partition{Name: "sda2", FilesystemLabel: "COS_OEM"})
+		Expect(part.MountPoint).To(Equal("/oem"))
+		Expect(part.OtherMountPoints).To(Equal([]string{"/mnt"}))
+		Expect(part.IsReadOnly).To(BeTrue())
+	})
+
+	It("aborts lsblk when the context is cancelled", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		part, _ := detectPartitionByLsblk(ctx, sleepRunner, "lsblk", "COS_OEM")
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(part.Found).To(BeFalse())
+	})
+
+	It("aborts findmnt when the context is cancelled", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		part, _ := detectPartitionByFindmnt(ctx, sleepRunner, "findmnt", &block.Partition{Name: "sda2", FilesystemLabel: "COS_OEM"})
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(part.Found).To(BeTrue())
+		Expect(part.Mounted).To(BeFalse())
+	})
+
+	It("reads the state and features of ext filesystems", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			Expect(command).To(Equal("dumpe2fs -h /dev/sda5"))
+			return `dumpe2fs 1.46.4 (18-Aug-2021)
+Filesystem volume name:   COS_PERSISTENT
+Filesystem features:      has_journal ext_attr resize_inode dir_index filetype extent 64bit flex_bg metadata_csum
+Filesystem state:         not clean
+`, nil
+		}
+		p := &PartitionState{Found: true, Name: "/dev/sda5", Type: "ext4"}
+		detectFilesystemState(context.Background(), runner, p)
+		Expect(p.NeedsCheck).To(BeTrue())
+		Expect(p.FSFeatures).To(ContainElements("has_journal", "64bit", "metadata_csum"))
+		Expect(p.FSFeatures).To(HaveLen(9))
+	})
+
+	It("skips the features when dumpe2fs is missing", func() {
+		runner := func(_ context.Context, _ string) (string, error) {
+			return "/bin/sh: dumpe2fs: not found", errors.New("exit status 127")
+		}
+		p := &PartitionState{Found: true, Name: "/dev/sda5", Type: "ext4"}
+		detectFilesystemState(context.Background(), runner, p)
+		Expect(p.NeedsCheck).To(BeFalse())
+		Expect(p.FSFeatures).To(BeEmpty())
+	})
+
+	It("reads the removable and hotplug flags of disks from sysfs", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/host6/block/sdb/removable": "1\n",
+			"/sys/devices/pci0000:00/ata1/host0/block/sda/removable":                  "0\n",
+			"/sys/class/block/sdb": &vfst.Symlink{Target: "../../devices/pci0000:00/0000:00:14.0/usb2/2-1/host6/block/sdb"},
+			"/sys/class/block/sda": &vfst.Symlink{Target: "../../devices/pci0000:00/ata1/host0/block/sda"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(diskState(fs, &block.Disk{Name: "sdb", SizeBytes: 1024, Model: "Flash Disk"})).To(Equal(DiskState{
+			Name: "/dev/sdb", SizeBytes: 1024, Model: "Flash Disk", Removable: true, Hotplug: true, PartitionTable: PartitionTableUnknown, Aligned: true,
+		}))
+		Expect(diskState(fs, &block.Disk{Name: "sda", SizeBytes: 2048})).To(Equal(DiskState{Name: "/dev/sda", SizeBytes: 2048, PartitionTable: PartitionTableUnknown, Aligned: true}))
+		Expect(diskState(fs, &block.Disk{Name: "sdc", BusPath: "pci-0000:00:14.0-usb-0:2:1.0-scsi-0:0:0:0"}).Hotplug).To(BeTrue())
+	})
+
+	It("reads the sector sizes of disks from sysfs", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/sys/devices/pci0000:00/ata1/host0/block/sda/queue/physical_block_size": "4096\n",
+			"/sys/devices/pci0000:00/ata1/host0/block/sda/queue/logical_block_size":  "512\n",
+			"/sys/class/block/sda": &vfst.Symlink{Target: "../../devices/pci0000:00/ata1/host0/block/sda"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		disk := diskState(fs, &block.Disk{Name: "sda", PhysicalBlockSizeBytes: 512})
+		Expect(disk.PhysicalSectorSize).To(Equal(uint64(4096)))
+		Expect(disk.LogicalSectorSize).To(Equal(uint64(512)))
+
+		disk = diskState(fs, &block.Disk{Name: "sdb", PhysicalBlockSizeBytes: 4096})
+		Expect(disk.PhysicalSectorSize).To(Equal(uint64(4096)))
+		Expect(disk.LogicalSectorSize).To(BeZero())
+	})
+
+	It("measures the space used and left on a filesystem", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/efi/EFI/BOOT/bootx64.efi": "MZ"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		used, free, err := filesystemUsage(fs, "/efi")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(used).To(BeNumerically(">", 0))
+		Expect(free).To(BeNumerically(">", 0))
+
+		_, _, err = filesystemUsage(fs, "/missing")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("looks up with lsblk all the partitions not found yet", func() {
+		o := DefaultOptions()
+		o.Runner = func(_ context.Context, command string) (string, error) {
+			for _, label := range []string{"COS_PERSISTENT", "COS_GRUB"} {
+				if strings.Contains(command, "/dev/disk/by-label/"+label+" ") {
+					return `{"blockdevices": [{"path": "/dev/sda9", "mountpoint": "/mnt", "label": "` + label + `"}]}`, nil
+				}
+			}
+			return "", errors.New("exit status 32")
+		}
+		r := &Runtime{OEM: PartitionState{Found: true, Name: "/dev/sda2"}}
+		detectPartitionsByLsblk(context.Background(), r, o, []string{EFIPartitionKey, "oem", "persistent", "state"})
+		Expect(r.OEM.Name).To(Equal("/dev/sda2"))
+		Expect(r.Persistent.Found).To(BeTrue())
+		Expect(r.Persistent.FilesystemLabel).To(Equal("COS_PERSISTENT"))
+		Expect(r.State.Found).To(BeFalse())
+		Expect(r.Extra[EFIPartitionKey].FilesystemLabel).To(Equal("COS_GRUB"))
+	})
+
+	It("flags disks with misaligned partitions", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/sys/devices/pci0000:00/block/sda/queue/optimal_io_size": "1048576\n",
+			"/sys/devices/pci0000:00/block/sda/sda1/partition":        "1\n",
+			"/sys/devices/pci0000:00/block/sda/sda1/start":            "2048\n",
+			"/sys/devices/pci0000:00/block/sda/sda1/size":             "2048\n",
+			"/sys/devices/pci0000:00/block/sda/sda2/partition":        "2\n",
+			"/sys/devices/pci0000:00/block/sda/sda2/start":            "4097\n",
+			"/sys/devices/pci0000:00/block/sda/sda2/size":             "2048\n",
+			"/sys/class/block/sda1":                                   &vfst.Symlink{Target: "../../devices/pci0000:00/block/sda/sda1"},
+			"/sys/class/block/sda2":                                   &vfst.Symlink{Target: "../../devices/pci0000:00/block/sda/sda2"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		Expect(diskState(fs, &block.Disk{Name: "sda", Partitions: []*block.Partition{{Name: "sda1"}}}).Aligned).To(BeTrue())
+		Expect(diskState(fs, &block.Disk{Name: "sda", Partitions: []*block.Partition{{Name: "sda1"}, {Name: "sda2"}}}).Aligned).To(BeFalse())
+	})
+
+	It("only returns the lsblk errors with FailFast", func() {
+		o := DefaultOptions()
+		o.Runner = func(_ context.Context, command string) (string, error) {
+			return "sh: lsblk: not found", errors.New("exit status 127")
+		}
+		r := &Runtime{}
+		Expect(detectPartitionsByLsblk(context.Background(), r, o, []string{"oem"})).To(Succeed())
+		Expect(r.OEM.Found).To(BeFalse())
+
+		o.FailFast = true
+		err := detectPartitionsByLsblk(context.Background(), r, o, []string{"oem"})
+		Expect(err).To(MatchError(ContainSubstring("detecting the COS_OEM partition")))
+		Expect(err).To(MatchError(ContainSubstring("lsblk: not found")))
+	})
+
+	It("doesn't take a missing label for an lsblk error with FailFast", func() {
+		o := DefaultOptions()
+		o.FailFast = true
+		o.Runner = func(_ context.Context, command string) (string, error) {
+			return "lsblk: /dev/disk/by-label/COS_OEM: not a block device", errors.New("exit status 32")
+		}
+		r := &Runtime{}
+		Expect(detectPartitionsByLsblk(context.Background(), r, o, []string{"oem"})).To(Succeed())
+		Expect(r.OEM.Found).To(BeFalse())
+	})
+
+	It("fails on unparsable lsblk output", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			return "{", nil
+		}
+		_, err := detectPartitionByLsblk(context.Background(), runner, "lsblk", "COS_OEM")
+		Expect(err).To(MatchError(ContainSubstring("parsing the lsblk output")))
+	})
+
+	It("doesn't take an unmounted partition for a findmnt error", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			return "", errors.New("exit status 1")
+		}
+		part, err := detectPartitionByFindmnt(context.Background(), runner, "findmnt", &block.Partition{Name: "sda2", FilesystemLabel: "COS_OEM"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(part.Mounted).To(BeFalse())
+
+		runner = func(_ context.Context, command string) (string, error) {
+			return "findmnt: can't read /proc/self/mountinfo", errors.New("exit status 1")
+		}
+		_, err = detectPartitionByFindmnt(context.Background(), runner, "findmnt", &block.Partition{Name: "sda2", FilesystemLabel: "COS_OEM"})
+		Expect(err).To(MatchError(ContainSubstring("can't read /proc/self/mountinfo")))
+	})
+})
@@ -0,0 +1,46 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jaypipes/ghw/pkg/block"
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("NewRuntimeWithProgress", func() {
+	It("reports each stage as it goes", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/cmdline": "root=LABEL=COS_ACTIVE"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		noTools := WithCommandRunner(func(_ context.Context, _ string) (string, error) {
+			return "", errors.New("exit status 1")
+		})
+		disks := []*block.Disk{
+			{Name: "sda", Partitions: []*block.Partition{{Name: "sda1"}, {Name: "sda2", FilesystemLabel: "COS_OEM"}}},
+			{Name: "sdb", Partitions: []*block.Partition{{Name: "sdb1"}}},
+		}
+
+		steps := []string{}
+		progress := func(stage string, done, total int) {
+			steps = append(steps, fmt.Sprintf("%s %d/%d", stage, done, total))
+		}
+		_, err = NewRuntimeWithProgress(context.Background(), progress, WithFS(fs), noTools, WithHost(disks, SystemInfo{}, nil))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(steps).To(Equal([]string{
+			"kairos 1/1",
+			"sysinfo 0/1",
+			"sysinfo 1/1",
+			"disks 0/2",
+			"partitions 1/3",
+			"partitions 2/3",
+			"disks 1/2",
+			"partitions 3/3",
+			"disks 2/2",
+		}))
+	})
+})
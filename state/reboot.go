@@ -0,0 +1,39 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// rebootRequiredFiles are the markers distro tooling leaves when an update needs a reboot to apply
+var rebootRequiredFiles = []string{"/run/reboot-required", "/var/run/reboot-required"}
+
+// RebootPending returns whether the node has changes that only apply after a reboot, with the reasons why:
+//   - a reboot-required marker was left by the package tooling
+//   - the active image was replaced after the node booted, like after an upgrade
+//   - grub has a one-shot entry set to boot next
+//
+// It never fails, markers that can't be read are not reported.
+func (r Runtime) RebootPending() (bool, []string) {
+	fs := r.filesystem()
+	reasons := []string{}
+	for _, f := range rebootRequiredFiles {
+		if exists(fs, f) {
+			reasons = append(reasons, fmt.Sprintf("%s is present", f))
+			break
+		}
+	}
+
+	if r.State.Mounted && !r.BootTime.IsZero() {
+		active := filepath.Join(r.State.MountPoint, stateImagesDir, "active.img")
+		if info, err := fs.Stat(active); err == nil && info.ModTime().After(r.BootTime) {
+			reasons = append(reasons, fmt.Sprintf("%s changed after boot", active))
+		}
+	}
+
+	if env, err := r.GrubEnv(); err == nil && strings.TrimSpace(env["next_entry"]) != "" {
+		reasons = append(reasons, fmt.Sprintf("grub will boot %s next", env["next_entry"]))
+	}
+	return len(reasons) > 0, reasons
+}
@@ -0,0 +1,55 @@
+package state_test
+
+import (
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("RebootPending", func() {
+	var r Runtime
+
+	BeforeEach(func() {
+		r = Runtime{
+			State:    PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-state"},
+			BootTime: time.Now().Add(time.Hour),
+		}
+	})
+
+	pending := func(files map[string]interface{}) (bool, []string) {
+		fs, cleanup, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		return r.WithFS(fs).RebootPending()
+	}
+
+	It("is not pending without markers", func() {
+		p, reasons := pending(map[string]interface{}{"/run/initramfs/cos-state/cOS/active.img": ""})
+		Expect(p).To(BeFalse())
+		Expect(reasons).To(BeEmpty())
+	})
+
+	It("is pending when the tooling asks for it", func() {
+		p, reasons := pending(map[string]interface{}{"/run/reboot-required": ""})
+		Expect(p).To(BeTrue())
+		Expect(reasons).To(Equal([]string{"/run/reboot-required is present"}))
+	})
+
+	It("is pending when the active image changed after boot", func() {
+		r.BootTime = time.Now().Add(-time.Hour)
+		p, reasons := pending(map[string]interface{}{"/run/initramfs/cos-state/cOS/active.img": ""})
+		Expect(p).To(BeTrue())
+		Expect(reasons).To(Equal([]string{"/run/initramfs/cos-state/cOS/active.img changed after boot"}))
+	})
+
+	It("is pending when grub has a next entry", func() {
+		p, reasons := pending(map[string]interface{}{
+			"/run/initramfs/cos-state/grubenv": "# GRUB Environment Block\nnext_entry=recovery\n",
+		})
+		Expect(p).To(BeTrue())
+		Expect(reasons).To(Equal([]string{"grub will boot recovery next"}))
+	})
+})
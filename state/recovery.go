@@ -0,0 +1,50 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// recoveryBootFiles are the kernel and initrd extracted next to a squashfs recovery image, grub can't load them
+// from inside of it like it does for img ones
+var recoveryBootFiles = []string{"boot/vmlinuz", "boot/initrd"}
+
+// RecoveryBootable returns whether the recovery system looks like it can boot, with the reasons why not:
+//   - recovery is not mounted, so it can't be checked
+//   - there is no recovery image
+//   - the kernel or initrd of a squashfs recovery are missing
+//   - the bootloader has no recovery entry
+//
+// It only looks for the files, a corrupted image can still fail to boot.
+func (r Runtime) RecoveryBootable() (bool, []string) {
+	format, err := r.RecoveryFormat()
+	if err != nil {
+		return false, []string{err.Error()}
+	}
+	fs := r.filesystem()
+	reasons := []string{}
+	switch format {
+	case RecoveryFormatUnknown:
+		reasons = append(reasons, fmt.Sprintf("no recovery image in %s", filepath.Join(r.Recovery.MountPoint, stateImagesDir)))
+	case RecoveryFormatSquashfs:
+		for _, f := range recoveryBootFiles {
+			if path := filepath.Join(r.Recovery.MountPoint, f); !exists(fs, path) {
+				reasons = append(reasons, fmt.Sprintf("%s is missing", path))
+			}
+		}
+	}
+
+	entries, _ := r.BootEntries()
+	found := false
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Title), "recovery") {
+			found = true
+			break
+		}
+	}
+	if !found {
+		reasons = append(reasons, "no recovery boot entry")
+	}
+	return len(reasons) == 0, reasons
+}
@@ -0,0 +1,67 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var recoveryGrubCfg = grubCfg + `
+menuentry "Kairos recovery" --id recovery {
+  search --no-floppy --label --set=root COS_RECOVERY
+  linux ($root)/boot/vmlinuz root=LABEL=COS_SYSTEM
+  initrd ($root)/boot/initrd
+}
+`
+
+var _ = Describe("RecoveryBootable", func() {
+	r := Runtime{Recovery: PartitionState{Found: true, Mounted: true, MountPoint: "/run/initramfs/cos-recovery"}}
+
+	bootable := func(files map[string]interface{}) (bool, []string) {
+		fs, cleanup, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		return r.WithFS(fs).RecoveryBootable()
+	}
+
+	It("is bootable with a squashfs, its kernel and initrd and a boot entry", func() {
+		ok, reasons := bootable(map[string]interface{}{
+			"/run/initramfs/cos-recovery/cOS/recovery.squashfs": "",
+			"/run/initramfs/cos-recovery/boot/vmlinuz":          "",
+			"/run/initramfs/cos-recovery/boot/initrd":           "",
+			"/boot/grub2/grub.cfg":                              recoveryGrubCfg,
+		})
+		Expect(ok).To(BeTrue())
+		Expect(reasons).To(BeEmpty())
+	})
+
+	It("doesn't need the kernel next to an img", func() {
+		ok, reasons := bootable(map[string]interface{}{
+			"/run/initramfs/cos-recovery/cOS/recovery.img": "",
+			"/boot/grub2/grub.cfg":                         recoveryGrubCfg,
+		})
+		Expect(ok).To(BeTrue())
+		Expect(reasons).To(BeEmpty())
+	})
+
+	It("reports what's missing", func() {
+		ok, reasons := bootable(map[string]interface{}{
+			"/run/initramfs/cos-recovery/cOS/recovery.squashfs": "",
+			"/run/initramfs/cos-recovery/boot/vmlinuz":          "",
+			"/boot/grub2/grub.cfg":                              grubCfg,
+		})
+		Expect(ok).To(BeFalse())
+		Expect(reasons).To(Equal([]string{"/run/initramfs/cos-recovery/boot/initrd is missing", "no recovery boot entry"}))
+
+		ok, reasons = bootable(map[string]interface{}{"/boot/grub2/grub.cfg": recoveryGrubCfg})
+		Expect(ok).To(BeFalse())
+		Expect(reasons).To(Equal([]string{"no recovery image in /run/initramfs/cos-recovery/cOS"}))
+	})
+
+	It("is not bootable when recovery is not mounted", func() {
+		ok, reasons := Runtime{Recovery: PartitionState{Found: true}}.RecoveryBootable()
+		Expect(ok).To(BeFalse())
+		Expect(reasons).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// NewRuntimeFromCommands builds a runtime only from the output of the commands run through the runner, like over
+// SSH on a node the SDK is not installed on. The boot state and Kairos release come from the cmdline and
+// os-release, and the partitions from lsblk. What needs ghw, sysinfo or reading files is left out.
+// The methods of the runtime calling tools go through the runner as well, but the ones reading files read local ones.
+// The UUID is built from the machine-id and hostname of the node, like utils.UUID does locally.
+func NewRuntimeFromCommands(ctx context.Context, runner CommandRunner) (Runtime, error) {
+	o := DefaultOptions()
+	o.Runner = runner
+	r := &Runtime{
+		BootState:        Unknown,
+		Bootloader:       BootloaderUnknown,
+		Init:             InitUnknown,
+		BootAttemptsLeft: -1,
+		BootAttemptsMax:  -1,
+		runner:           runner,
+	}
+
+	cmdline, err := runner(ctx, "cat /proc/cmdline")
+	if err != nil {
+		return *r, fmt.Errorf("could not read the cmdline: %w", err)
+	}
+	r.cmdline = parseCmdline(cmdline)
+	r.BootState = bootFromParams(r.cmdline)
+
+	id, _ := runner(ctx, "cat /etc/machine-id")
+	hostname, _ := runner(ctx, "hostname")
+	r.UUID = fmt.Sprintf("%s-%s", strings.TrimSpace(id), strings.TrimSpace(hostname))
+
+	if out, err := runner(ctx, "cat /etc/os-release"); err == nil {
+		if release, err := godotenv.Unmarshal(out); err == nil {
+			r.Kairos.Flavor, _ = osReleaseValue(release, "FLAVOR")
+			r.Kairos.Version, _ = osReleaseValue(release, "VERSION")
+		}
+	}
+
+	_ = detectPartitionsByLsblk(ctx, r, o, defaultLabelKeys())
+	for _, p := range []*PartitionState{&r.Persistent, &r.Recovery, &r.OEM, &r.State} {
+		detectFilesystemState(ctx, runner, p)
+	}
+	return *r, ctx.Err()
+}
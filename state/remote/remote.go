@@ -0,0 +1,60 @@
+// Package remote probes the runtime of a node over SSH, for nodes the SDK is not installed on
+package remote
+
+import (
+	"context"
+
+	"github.com/kairos-io/kairos-sdk/state"
+	"golang.org/x/crypto/ssh"
+)
+
+// ProbeOverSSH builds the runtime of the node the client is connected to from the output of the tools run on it,
+// see state.NewRuntimeFromCommands for what's left out
+func ProbeOverSSH(client *ssh.Client) (state.Runtime, error) {
+	return ProbeOverSSHWithContext(context.Background(), client)
+}
+
+// ProbeOverSSHWithContext is like ProbeOverSSH but stops running commands once the context is done
+func ProbeOverSSHWithContext(ctx context.Context, client *ssh.Client) (state.Runtime, error) {
+	return state.NewRuntimeFromCommands(ctx, Runner(client))
+}
+
+// Runner runs the commands in a new session of the client each, with the output of both stdout and stderr like
+// the local runner. The session is closed when the context is done, which is all that can stop the command.
+func Runner(client *ssh.Client) state.CommandRunner {
+	return sessionRunner(func() (session, error) {
+		return client.NewSession()
+	})
+}
+
+// session is the part of ssh.Session the runner uses, so it can be faked in tests
+type session interface {
+	CombinedOutput(cmd string) ([]byte, error)
+	Close() error
+}
+
+// sessionRunner runs each command in a session of its own, opened with newSession
+func sessionRunner(newSession func() (session, error)) state.CommandRunner {
+	return func(ctx context.Context, command string) (string, error) {
+		session, err := newSession()
+		if err != nil {
+			return "", err
+		}
+		defer session.Close()
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				session.Close()
+			case <-done:
+			}
+		}()
+		out, err := session.CombinedOutput(command)
+		if ctx.Err() != nil {
+			return string(out), ctx.Err()
+		}
+		return string(out), err
+	}
+}
@@ -0,0 +1,100 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSession answers the commands from a map, and blocks on the ones it doesn't know until it's closed, like a
+// command hanging on the node
+type fakeSession struct {
+	outputs map[string]string
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (f *fakeSession) CombinedOutput(cmd string) ([]byte, error) {
+	if out, ok := f.outputs[cmd]; ok {
+		return []byte(out), nil
+	}
+	for prefix, out := range f.outputs {
+		if strings.HasSuffix(prefix, "*") && strings.HasPrefix(cmd, strings.TrimSuffix(prefix, "*")) {
+			return []byte(out), nil
+		}
+	}
+	if strings.HasPrefix(cmd, "sleep") {
+		<-f.closed
+		return nil, errors.New("session closed")
+	}
+	return []byte(""), errors.New("Process exited with status 1")
+}
+
+func (f *fakeSession) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// fakeSessions opens a new fake session for every command, counting them
+func fakeSessions(outputs map[string]string, opened *int) func() (session, error) {
+	return func() (session, error) {
+		*opened++
+		return &fakeSession{outputs: outputs, closed: make(chan struct{})}, nil
+	}
+}
+
+var _ = Describe("sessionRunner", func() {
+	It("runs every command in a session of its own", func() {
+		opened := 0
+		runner := sessionRunner(fakeSessions(map[string]string{"hostname": "node-1\n"}, &opened))
+		out, err := runner(context.Background(), "hostname")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out).To(Equal("node-1\n"))
+		_, err = runner(context.Background(), "false")
+		Expect(err).To(HaveOccurred())
+		Expect(opened).To(Equal(2))
+	})
+
+	It("fails when the session can't be opened", func() {
+		runner := sessionRunner(func() (session, error) { return nil, errors.New("connection lost") })
+		_, err := runner(context.Background(), "hostname")
+		Expect(err).To(MatchError("connection lost"))
+	})
+
+	It("closes the session when the context is done", func() {
+		opened := 0
+		runner := sessionRunner(fakeSessions(map[string]string{}, &opened))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := runner(ctx, "sleep 60")
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("probes the runtime through the sessions", func() {
+		opened := 0
+		runner := sessionRunner(fakeSessions(map[string]string{
+			"cat /proc/cmdline":                       "BOOT_IMAGE=/cOS/vmlinuz root=LABEL=COS_RECOVERY\n",
+			"cat /etc/machine-id":                     "0123456789abcdef\n",
+			"hostname":                                "node-1\n",
+			"cat /etc/os-release":                     "KAIROS_FLAVOR=alpine\nKAIROS_VERSION=v2.4.0\n",
+			"lsblk /dev/disk/by-label/COS_OEM *":      `{"blockdevices": [{"path": "/dev/sda2", "mountpoint": "/oem", "fstype": "ext4", "label": "COS_OEM"}]}`,
+			"lsblk /dev/disk/by-label/COS_RECOVERY *": `{"blockdevices": [{"path": "/dev/sda3", "fstype": "squashfs", "label": "COS_RECOVERY"}]}`,
+		}, &opened))
+		r, err := state.NewRuntimeFromCommands(context.Background(), runner)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.UUID).To(Equal("0123456789abcdef-node-1"))
+		Expect(r.BootState).To(Equal(state.Recovery))
+		Expect(r.Kairos.Flavor).To(Equal("alpine"))
+		Expect(r.OEM.Name).To(Equal("/dev/sda2"))
+		Expect(r.Recovery.Name).To(Equal("/dev/sda3"))
+		Expect(r.Persistent.Found).To(BeFalse())
+	})
+})
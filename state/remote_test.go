@@ -0,0 +1,57 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewRuntimeFromCommands", func() {
+	It("builds the runtime from the output of the tools", func() {
+		runner := func(_ context.Context, command string) (string, error) {
+			switch {
+			case command == "cat /proc/cmdline":
+				return "BOOT_IMAGE=/cOS/vmlinuz console=tty1 root=LABEL=COS_ACTIVE rd.neednet=0\n", nil
+			case command == "cat /etc/machine-id":
+				return "0123456789abcdef0123456789abcdef\n", nil
+			case command == "hostname":
+				return "node-1\n", nil
+			case command == "cat /etc/os-release":
+				return "NAME=\"openSUSE Leap\"\nKAIROS_FLAVOR=opensuse\nKAIROS_VERSION=v2.4.0\n", nil
+			case strings.HasPrefix(command, "lsblk /dev/disk/by-label/COS_PERSISTENT "):
+				return `{"blockdevices": [{"path": "/dev/sda5", "mountpoint": "/usr/local", "fstype": "ext4", "label": "COS_PERSISTENT"}]}`, nil
+			case strings.HasPrefix(command, "lsblk /dev/disk/by-label/COS_OEM "):
+				return `{"blockdevices": [{"path": "/dev/sda2", "mountpoint": "/oem", "fstype": "ext4", "label": "COS_OEM"}]}`, nil
+			case command == "dumpe2fs -h /dev/sda5":
+				return "Filesystem state:         not clean\n", nil
+			}
+			return "", errors.New("exit status 1")
+		}
+		r, err := NewRuntimeFromCommands(context.Background(), runner)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.UUID).To(Equal("0123456789abcdef0123456789abcdef-node-1"))
+		Expect(r.BootState).To(Equal(Active))
+		Expect(r.Bootloader).To(Equal(BootloaderUnknown))
+		Expect(r.Init).To(Equal(InitUnknown))
+		Expect(r.Kairos).To(Equal(Kairos{Flavor: "opensuse", Version: "v2.4.0"}))
+		Expect(r.CmdlineParams()).To(HaveKeyWithValue("console", "tty1"))
+		Expect(r.Persistent).To(Equal(PartitionState{
+			Found: true, Name: "/dev/sda5", Mounted: true, MountPoint: "/usr/local", Type: "ext4", FilesystemLabel: "COS_PERSISTENT", NeedsCheck: true,
+		}))
+		Expect(r.OEM.Name).To(Equal("/dev/sda2"))
+		Expect(r.Recovery.Found).To(BeFalse())
+	})
+
+	It("fails when the node can't be reached", func() {
+		runner := func(_ context.Context, _ string) (string, error) {
+			return "", errors.New("connection reset")
+		}
+		r, err := NewRuntimeFromCommands(context.Background(), runner)
+		Expect(err).To(MatchError(ContainSubstring("connection reset")))
+		Expect(r.BootState).To(Equal(Unknown))
+	})
+})
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// DetectRemountedReadOnlyWithVFS returns whether a read-only mounted partition was meant to be read-write, which
+// usually means the kernel remounted it after I/O errors. That's the case when ext has recorded errors on it, or
+// when its fstab entry doesn't ask for ro and it's not a dm-crypt device opened read-only on purpose.
+// It uses a vfs so it can be used for tests as well.
+func DetectRemountedReadOnlyWithVFS(fs vfs.FS, p PartitionState) bool {
+	if !p.Mounted || !p.IsReadOnly || p.Name == "" {
+		return false
+	}
+	if count, err := readSysfsUint(fs, filepath.Join("/sys/fs/ext4", filepath.Base(p.Name), "errors_count")); err == nil && count > 0 {
+		return true
+	}
+	// Partitions are named after the real device, like /dev/dm-0, while crypttab knows them by their mapping
+	mapping := readSysfsString(fs, filepath.Join("/sys/class/block", filepath.Base(p.Name), "dm", "name"))
+	if mapping == "" && strings.HasPrefix(p.Name, "/dev/mapper/") {
+		mapping = filepath.Base(p.Name)
+	}
+	if mapping != "" {
+		if options, ok := tabOptions(fs, "/etc/crypttab", 0, mapping, 3); ok && (options["readonly"] || options["read-only"]) {
+			return false
+		}
+	}
+	options, ok := tabOptions(fs, "/etc/fstab", 1, p.MountPoint, 3)
+	return ok && !options["ro"]
+}
+
+// tabOptions finds the line of a fstab like file whose key column has the given value and returns the comma
+// separated options of its options column, which are the defaults if the line is too short to have one
+func tabOptions(fs vfs.FS, path string, keyColumn int, key string, optionsColumn int) (map[string]bool, bool) {
+	dat, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) <= keyColumn || strings.HasPrefix(fields[0], "#") || unescapeMountPath(fields[keyColumn]) != key {
+			continue
+		}
+		options := map[string]bool{}
+		if len(fields) > optionsColumn {
+			for _, o := range strings.Split(fields[optionsColumn], ",") {
+				options[o] = true
+			}
+		}
+		return options, true
+	}
+	return nil, false
+}
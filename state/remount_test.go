@@ -0,0 +1,46 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectRemountedReadOnlyWithVFS", func() {
+	persistent := PartitionState{Found: true, Mounted: true, IsReadOnly: true, Name: "/dev/sda5", MountPoint: "/usr/local"}
+	fstab := "# <fs> <mountpoint> <type> <options> <dump> <pass>\n" +
+		"/dev/disk/by-label/COS_OEM /oem ext4 ro 0 0\n" +
+		"/dev/disk/by-label/COS_PERSISTENT /usr/local ext4 defaults 0 0\n"
+
+	DescribeTable("tells remounts from intended read-only mounts",
+		func(p PartitionState, files map[string]interface{}, expected bool) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			Expect(DetectRemountedReadOnlyWithVFS(fs, p)).To(Equal(expected))
+		},
+		Entry("meant to be read-write", persistent, map[string]interface{}{"/etc/fstab": fstab}, true),
+		Entry("meant to be read-only", PartitionState{Found: true, Mounted: true, IsReadOnly: true, Name: "/dev/sda2", MountPoint: "/oem"},
+			map[string]interface{}{"/etc/fstab": fstab}, false),
+		Entry("read-write", PartitionState{Found: true, Mounted: true, Name: "/dev/sda5", MountPoint: "/usr/local"},
+			map[string]interface{}{"/etc/fstab": fstab}, false),
+		Entry("not in fstab", persistent, map[string]interface{}{"/etc/fstab": "/dev/sda1 /boot/efi vfat defaults 0 0\n"}, false),
+		Entry("with ext errors recorded", persistent, map[string]interface{}{"/sys/fs/ext4/sda5/errors_count": "3\n"}, true),
+		Entry("without ext errors", persistent, map[string]interface{}{"/sys/fs/ext4/sda5/errors_count": "0\n"}, false),
+		Entry("a dm-crypt device opened read-only", PartitionState{Found: true, Mounted: true, IsReadOnly: true, Name: "/dev/dm-0", MountPoint: "/usr/local"},
+			map[string]interface{}{
+				"/etc/fstab":    fstab,
+				"/etc/crypttab": "persistent /dev/sda5 none luks,readonly\n",
+				"/sys/devices/virtual/block/dm-0/dm/name": "persistent\n",
+				"/sys/class/block/dm-0":                   &vfst.Symlink{Target: "../../devices/virtual/block/dm-0"},
+			}, false),
+		Entry("a dm-crypt device opened read-write", PartitionState{Found: true, Mounted: true, IsReadOnly: true, Name: "/dev/dm-0", MountPoint: "/usr/local"},
+			map[string]interface{}{
+				"/etc/fstab":    fstab,
+				"/etc/crypttab": "persistent /dev/sda5 none luks\n",
+				"/sys/devices/virtual/block/dm-0/dm/name": "persistent\n",
+				"/sys/class/block/dm-0":                   &vfst.Symlink{Target: "../../devices/virtual/block/dm-0"},
+			}, true),
+	)
+})
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// CPUCount returns the number of CPUs of the running system, without going through sysinfo
+func CPUCount() int {
+	return CPUCountWithVFS(vfs.OSFS)
+}
+
+// CPUCountWithVFS counts the processors listed in /proc/cpuinfo using a vfs so it can be used for tests as well.
+// It's zero if it can't be read.
+func CPUCountWithVFS(fs vfs.FS) int {
+	dat, err := fs.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return 0
+	}
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		if key, _, found := strings.Cut(scanner.Text(), ":"); found && strings.TrimSpace(key) == "processor" {
+			count++
+		}
+	}
+	return count
+}
+
+// TotalMemoryBytes returns the memory of the running system, without going through sysinfo
+func TotalMemoryBytes() (uint64, error) {
+	return TotalMemoryBytesWithVFS(vfs.OSFS)
+}
+
+// TotalMemoryBytesWithVFS reads the MemTotal of /proc/meminfo using a vfs so it can be used for tests as well
+func TotalMemoryBytesWithVFS(fs vfs.FS) (uint64, error) {
+	dat, err := fs.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		total, found := strings.CutPrefix(scanner.Text(), "MemTotal:")
+		if !found {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(total), "kB")), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing MemTotal: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
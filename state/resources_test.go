@@ -0,0 +1,45 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Resources", func() {
+	It("counts the CPUs", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/cpuinfo": "processor\t: 0\nvendor_id\t: GenuineIntel\n\nprocessor\t: 1\nvendor_id\t: GenuineIntel\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		Expect(CPUCountWithVFS(fs)).To(Equal(2))
+	})
+
+	It("returns no CPUs without cpuinfo", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/meminfo": ""})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		Expect(CPUCountWithVFS(fs)).To(BeZero())
+	})
+
+	It("reads the total memory", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/meminfo": "MemTotal:        8036532 kB\nMemFree:          512000 kB\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		total, err := TotalMemoryBytesWithVFS(fs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(total).To(Equal(uint64(8036532 * 1024)))
+	})
+
+	It("fails without MemTotal", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/meminfo": "MemFree: 512000 kB\n"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+		_, err = TotalMemoryBytesWithVFS(fs)
+		Expect(err).To(HaveOccurred())
+	})
+})
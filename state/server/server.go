@@ -0,0 +1,149 @@
+// Package server serves the runtime of the node over HTTP, probing it once and answering many queries from the
+// cached snapshot instead of probing on every request.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kairos-io/kairos-sdk/state"
+)
+
+// ProbeFunc probes the runtime, like state.NewRuntimeWithOptions does
+type ProbeFunc func(ctx context.Context) (state.Runtime, error)
+
+// StateServer caches the runtime returned by its probe and serves queries against it. The snapshot is refreshed
+// every interval with Run, on demand once it's older than the TTL, or both. Only one probe runs at a time, callers
+// needing a refresh while one is running wait for it instead of probing again.
+type StateServer struct {
+	probe ProbeFunc
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	snapshot state.Runtime
+	probedAt time.Time
+	err      error
+	// probing is closed once the running probe is done, it's nil when there is none
+	probing chan struct{}
+}
+
+// NewStateServer returns a server probing with the given func. A TTL of zero never probes on demand, except for the
+// first snapshot, so the snapshot is only refreshed by Run.
+func NewStateServer(probe ProbeFunc, ttl time.Duration) *StateServer {
+	return &StateServer{probe: probe, ttl: ttl}
+}
+
+// Run refreshes the snapshot every interval until the context is done. The probes get the context, and Run only
+// returns once the last one is done.
+func (s *StateServer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		<-s.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh probes the runtime right away, or waits for the probe already running. When the probe fails the previous
+// snapshot is kept, and the error is returned by Snapshot until a probe succeeds. A probe cut short by the context
+// being done leaves both the snapshot and the error alone.
+func (s *StateServer) Refresh(ctx context.Context) {
+	select {
+	case <-s.refresh(ctx):
+	case <-ctx.Done():
+	}
+}
+
+// refresh starts probing with the given context unless a probe is already running, and returns the channel closed
+// once the probe is done
+func (s *StateServer) refresh(ctx context.Context) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probing != nil {
+		return s.probing
+	}
+	done := make(chan struct{})
+	s.probing = done
+	go func() {
+		r, err := s.probe(ctx)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		defer close(done)
+		s.probing = nil
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		s.err = err
+		if err == nil {
+			s.snapshot, s.probedAt = r, time.Now()
+		}
+	}()
+	return done
+}
+
+// Snapshot returns the cached runtime and how old it is, probing first if there is none yet or it's older than the
+// TTL. The error is the one of the last probe, in which case the runtime is the last good one, if any.
+func (s *StateServer) Snapshot(ctx context.Context) (state.Runtime, time.Duration, error) {
+	r, age, _, err := s.cached(ctx)
+	return r, age, err
+}
+
+// cached is Snapshot, also telling whether there is a snapshot at all. On demand probes run with a context of their
+// own, so a client going away doesn't cut the probe short for the others waiting on it. The context only bounds how
+// long this caller waits.
+func (s *StateServer) cached(ctx context.Context) (state.Runtime, time.Duration, bool, error) {
+	s.mu.Lock()
+	stale := s.probedAt.IsZero() || (s.ttl > 0 && time.Since(s.probedAt) >= s.ttl)
+	s.mu.Unlock()
+	if stale {
+		select {
+		case <-s.refresh(context.Background()):
+		case <-ctx.Done():
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probedAt.IsZero() {
+		if s.err == nil && ctx.Err() != nil {
+			return state.Runtime{}, 0, false, ctx.Err()
+		}
+		return state.Runtime{}, 0, false, s.err
+	}
+	return s.snapshot.Clone(), time.Since(s.probedAt), true, s.err
+}
+
+// ServeHTTP answers with the result of the query in the q parameter, or with the whole runtime as json without one.
+// The age of the snapshot, in seconds, is in the Age header. A stale snapshot is served when probing fails, and
+// only if there is none the request fails.
+func (s *StateServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r, age, ok, err := s.cached(req.Context())
+	if !ok {
+		http.Error(w, fmt.Sprintf("probing the runtime: %s", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+
+	q := req.URL.Query().Get("q")
+	if q == "" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r)
+		return
+	}
+	res, err := r.QueryWithContext(req.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprint(w, res)
+}
@@ -0,0 +1,175 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kairos-io/kairos-sdk/state"
+	. "github.com/kairos-io/kairos-sdk/state/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StateServer", func() {
+	var probes int
+	var probeErr error
+	var probe ProbeFunc
+
+	BeforeEach(func() {
+		probes, probeErr = 0, nil
+		probe = func(_ context.Context) (state.Runtime, error) {
+			probes++
+			if probeErr != nil {
+				return state.Runtime{}, probeErr
+			}
+			return state.Runtime{
+				UUID:       "uuid",
+				BootState:  state.Active,
+				Persistent: state.PartitionState{Found: true, Mounted: true, MountPoint: "/usr/local"},
+			}, nil
+		}
+	})
+
+	get := func(s *StateServer, url string) (*http.Response, string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		body, err := io.ReadAll(rec.Result().Body)
+		Expect(err).ToNot(HaveOccurred())
+		return rec.Result(), string(body)
+	}
+
+	It("probes once and answers many queries", func() {
+		s := NewStateServer(probe, 0)
+		for i := 0; i < 3; i++ {
+			res, body := get(s, "/?q=persistent.mount_point")
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("/usr/local"))
+			Expect(res.Header.Get("Age")).To(Equal("0"))
+		}
+		Expect(probes).To(Equal(1))
+	})
+
+	It("serves the whole runtime without a query", func() {
+		res, body := get(NewStateServer(probe, 0), "/")
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(body).To(HavePrefix(`{"uuid":"uuid",`))
+	})
+
+	It("probes again once the snapshot is older than the TTL", func() {
+		s := NewStateServer(probe, 10*time.Millisecond)
+		_, _, err := s.Snapshot(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		time.Sleep(20 * time.Millisecond)
+		_, age, err := s.Snapshot(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(age).To(BeNumerically("<", 10*time.Millisecond))
+		Expect(probes).To(Equal(2))
+	})
+
+	It("refreshes the snapshot with Run", func() {
+		s := NewStateServer(probe, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		s.Run(ctx, 10*time.Millisecond)
+		Expect(probes).To(BeNumerically(">", 2))
+	})
+
+	It("keeps serving the last snapshot when probing fails", func() {
+		s := NewStateServer(probe, 0)
+		s.Refresh(context.Background())
+		probeErr = errors.New("lsblk failed")
+		s.Refresh(context.Background())
+
+		r, _, err := s.Snapshot(context.Background())
+		Expect(err).To(MatchError("lsblk failed"))
+		Expect(r.UUID).To(Equal("uuid"))
+		res, body := get(s, "/?q=uuid")
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("uuid"))
+	})
+
+	It("fails when there is nothing to serve", func() {
+		probeErr = errors.New("lsblk failed")
+		res, body := get(NewStateServer(probe, 0), "/?q=uuid")
+		Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(ContainSubstring("lsblk failed"))
+	})
+
+	It("rejects invalid queries", func() {
+		res, _ := get(NewStateServer(probe, 0), "/?q=uuid|")
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("probes once for the requests arriving while it probes", func() {
+		var running int32
+		release := make(chan struct{})
+		s := NewStateServer(func(ctx context.Context) (state.Runtime, error) {
+			atomic.AddInt32(&running, 1)
+			<-release
+			return probe(ctx)
+		}, 0)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				r, _, err := s.Snapshot(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(r.UUID).To(Equal("uuid"))
+			}()
+		}
+		Eventually(func() int32 { return atomic.LoadInt32(&running) }).Should(Equal(int32(1)))
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		Expect(atomic.LoadInt32(&running)).To(Equal(int32(1)))
+	})
+
+	It("doesn't let a client going away fail the probe for the others", func() {
+		release := make(chan struct{})
+		s := NewStateServer(func(ctx context.Context) (state.Runtime, error) {
+			select {
+			case <-release:
+				return probe(ctx)
+			case <-ctx.Done():
+				return state.Runtime{}, ctx.Err()
+			}
+		}, 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err := s.Snapshot(ctx)
+		Expect(err).To(MatchError(context.Canceled))
+
+		close(release)
+		r, _, err := s.Snapshot(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.UUID).To(Equal("uuid"))
+	})
+
+	It("doesn't keep the errors of a cancelled refresh", func() {
+		s := NewStateServer(func(ctx context.Context) (state.Runtime, error) {
+			if ctx.Err() != nil {
+				return state.Runtime{}, ctx.Err()
+			}
+			return probe(ctx)
+		}, 0)
+		s.Refresh(context.Background())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Refresh(ctx)
+		// Let the cancelled probe finish, Refresh doesn't wait for it once its context is done
+		time.Sleep(20 * time.Millisecond)
+		_, _, err := s.Snapshot(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -1,17 +1,25 @@
 package state
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/itchyny/gojq"
 	"github.com/jaypipes/ghw"
 	"github.com/jaypipes/ghw/pkg/block"
+	"github.com/joho/godotenv"
 	"github.com/kairos-io/kairos-sdk/types"
 	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/twpayne/go-vfs/v4"
 	"github.com/zcalusic/sysinfo"
 	"gopkg.in/yaml.v3"
 )
@@ -20,23 +28,95 @@ const (
 	Active   Boot = "active_boot"
 	Passive  Boot = "passive_boot"
 	Recovery Boot = "recovery_boot"
+	Reset    Boot = "reset_boot"
 	LiveCD   Boot = "livecd_boot"
 	Unknown  Boot = "unknown"
 )
 
 type Boot string
 
+// Queries are limited by default, so that an expression like `.. | ..` can't eat all the memory of a service
+// exposing them. The limits can be changed with WithQueryLimits.
+const (
+	DefaultQueryMaxResults = 10000
+	DefaultQueryMaxBytes   = 16 * 1024 * 1024
+)
+
+// ErrQueryLimit is returned when the output of a query goes over its limits
+var ErrQueryLimit = errors.New("query output limit exceeded")
+
+// ErrUnknownBoot is returned when probing with WithStrictBoot and the boot state can't be detected
+var ErrUnknownBoot = errors.New("could not detect the boot state")
+
+// AllBootStates returns every boot state that can be detected, Unknown included
+func AllBootStates() []Boot {
+	return []Boot{Active, Passive, Recovery, Reset, LiveCD, Unknown}
+}
+
+// Valid returns whether b is one of the defined boot states
+func (b Boot) Valid() bool {
+	for _, s := range AllBootStates() {
+		if b == s {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	BootloaderGrub        = "grub"
+	BootloaderSystemdBoot = "systemd-boot"
+	BootloaderUBoot       = "u-boot"
+	BootloaderUnknown     = "unknown"
+)
+
+// DefaultLabels maps the partitions of the runtime to the filesystem labels they are detected by. It can be changed
+// at init time for layouts using other labels. Keys other than persistent, recovery, oem and state are detected
+// too, and stored under the key in Runtime.Extra.
+var DefaultLabels = map[string]string{
+	"persistent": "COS_PERSISTENT",
+	"recovery":   "COS_RECOVERY",
+	"oem":        "COS_OEM",
+	"state":      "COS_STATE",
+	// The EFI system partition ends up in Runtime.Extra, with its space usage
+	EFIPartitionKey: "COS_GRUB",
+}
+
+// EFIPartitionKey is the key of the EFI system partition in DefaultLabels and Runtime.Extra
+const EFIPartitionKey = "efi"
+
 type PartitionState struct {
-	Mounted         bool   `yaml:"mounted" json:"mounted"`
-	Name            string `yaml:"name" json:"name"`
-	Label           string `yaml:"label" json:"label"`
-	FilesystemLabel string `yaml:"filesystemlabel" json:"filesystemlabel"`
-	MountPoint      string `yaml:"mount_point" json:"mount_point"`
-	SizeBytes       uint64 `yaml:"size_bytes" json:"size_bytes"`
-	Type            string `yaml:"type" json:"type"`
-	IsReadOnly      bool   `yaml:"read_only" json:"read_only"`
-	Found           bool   `yaml:"found" json:"found"`
-	UUID            string `yaml:"uuid" json:"uuid"` // This would be volume UUID on macOS, PartUUID on linux, empty on Windows
+	Mounted          bool     `yaml:"mounted" json:"mounted"`
+	Name             string   `yaml:"name" json:"name"`
+	Label            string   `yaml:"label" json:"label"`
+	FilesystemLabel  string   `yaml:"filesystem_label" json:"filesystem_label"`
+	MountPoint       string   `yaml:"mount_point" json:"mount_point"`
+	OtherMountPoints []string `yaml:"other_mount_points,omitempty" json:"other_mount_points,omitempty"` // Where else the partition is mounted, like bind mounts
+	SizeBytes        uint64   `yaml:"size_bytes" json:"size_bytes"`
+	Type             string   `yaml:"type" json:"type"`
+	IsReadOnly       bool     `yaml:"read_only" json:"read_only"`
+	Found            bool     `yaml:"found" json:"found"`
+	UUID             string   `yaml:"uuid" json:"uuid"`                                   // This would be volume UUID on macOS, PartUUID on linux, empty on Windows
+	Propagation      string   `yaml:"propagation,omitempty" json:"propagation,omitempty"` // Only set for mounted partitions
+	NeedsCheck       bool     `yaml:"needs_check" json:"needs_check"`                     // Only detected for ext filesystems
+	FSFeatures       []string `yaml:"fs_features,omitempty" json:"fs_features,omitempty"` // Only detected for ext filesystems
+	DeviceLink       string   `yaml:"device_link,omitempty" json:"device_link,omitempty"` // Original path when Name was resolved from a symlink
+	Encrypted        bool     `yaml:"encrypted" json:"encrypted"`
+	UnlockMethod     string   `yaml:"unlock_method" json:"unlock_method"` // One of tpm, passphrase or none
+	Role             string   `yaml:"role,omitempty" json:"role,omitempty"`
+	// UsedBytes and FreeBytes are the space used and left on the filesystem, only measured for the EFI partition
+	UsedBytes uint64 `yaml:"used_bytes,omitempty" json:"used_bytes,omitempty"`
+	FreeBytes uint64 `yaml:"free_bytes,omitempty" json:"free_bytes,omitempty"`
+	// IOLatency is how long a synced write under the mountpoint took, it's only measured for persistent when probing
+	// with WithIOLatency. A slow but mounted persistent is usually a dying disk.
+	IOLatency time.Duration `yaml:"io_latency,omitempty" json:"io_latency,omitempty"`
+	// StartOffsetBytes is where the partition starts on its disk, see PartitionAlignmentWithVFS
+	StartOffsetBytes uint64 `yaml:"start_offset_bytes" json:"start_offset_bytes"`
+	Aligned          bool   `yaml:"aligned" json:"aligned"`
+	// RemountedReadOnly is set when the partition is read-only but was meant to be read-write, like after I/O errors
+	RemountedReadOnly bool `yaml:"remounted_read_only" json:"remounted_read_only"`
+	// DeviceChain lists the devices below Name when it's a device mapper one, see DeviceChainWithVFS
+	DeviceChain []string `yaml:"device_chain,omitempty" json:"device_chain,omitempty"`
 }
 
 type Kairos struct {
@@ -45,14 +125,122 @@ type Kairos struct {
 }
 
 type Runtime struct {
-	UUID       string          `yaml:"uuid" json:"uuid"`
-	Persistent PartitionState  `yaml:"persistent" json:"persistent"`
-	Recovery   PartitionState  `yaml:"recovery" json:"recovery"`
-	OEM        PartitionState  `yaml:"oem" json:"oem"`
-	State      PartitionState  `yaml:"state" json:"state"`
-	BootState  Boot            `yaml:"boot" json:"boot"`
-	System     sysinfo.SysInfo `yaml:"system" json:"system"`
-	Kairos     Kairos          `yaml:"kairos" json:"kairos"`
+	UUID       string         `yaml:"uuid" json:"uuid"`
+	Persistent PartitionState `yaml:"persistent" json:"persistent"`
+	Recovery   PartitionState `yaml:"recovery" json:"recovery"`
+	OEM        PartitionState `yaml:"oem" json:"oem"`
+	State      PartitionState `yaml:"state" json:"state"`
+	BootState  Boot           `yaml:"boot" json:"boot"`
+	Bootloader string         `yaml:"bootloader" json:"bootloader"`
+	Init       string         `yaml:"init" json:"init"`
+	System     SystemInfo     `yaml:"system" json:"system"`
+	Kairos     Kairos         `yaml:"kairos" json:"kairos"`
+	Cluster    ClusterState   `yaml:"cluster" json:"cluster"`
+	Network    NetworkState   `yaml:"network" json:"network"`
+	// Uptime and BootTime are a snapshot taken when probing, they are not updated afterwards
+	Uptime   time.Duration `yaml:"uptime" json:"uptime"`
+	BootTime time.Time     `yaml:"boot_time" json:"boot_time"`
+	// EFIBootOrder and EFICurrent are the firmware boot entries, like Boot0001 followed by their description
+	EFIBootOrder []string `yaml:"efi_boot_order,omitempty" json:"efi_boot_order,omitempty"`
+	EFICurrent   string   `yaml:"efi_current,omitempty" json:"efi_current,omitempty"`
+	// BootAttemptsLeft and BootAttemptsMax are the systemd-boot counters of the entry booted, before it falls back
+	// to another one. They are -1 when the entry has no counters.
+	BootAttemptsLeft int `yaml:"boot_attempts_left" json:"boot_attempts_left"`
+	BootAttemptsMax  int `yaml:"boot_attempts_max" json:"boot_attempts_max"`
+	// Timezone and Locale are empty when they can't be read
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	Locale   string `yaml:"locale,omitempty" json:"locale,omitempty"`
+	// CPUCount and MemoryBytes are always probed, even when sysinfo is skipped
+	CPUCount    int    `yaml:"cpu_count" json:"cpu_count"`
+	MemoryBytes uint64 `yaml:"memory_bytes" json:"memory_bytes"`
+	// BootConfigProtected is set when the boot configs are all on read-only mounts or immutable
+	BootConfigProtected bool `yaml:"boot_config_protected" json:"boot_config_protected"`
+	// Immutable is set when the root is read-only with overlays for the writable paths, not just read-only
+	Immutable bool `yaml:"immutable" json:"immutable"`
+	// DegradedBoot is set when the system booted into the emergency or rescue target, whatever the BootState is
+	DegradedBoot bool `yaml:"degraded_boot" json:"degraded_boot"`
+	// StorageDrivers are the storage related kernel modules loaded, handy when no partition was found
+	StorageDrivers []string `yaml:"storage_drivers,omitempty" json:"storage_drivers,omitempty"`
+	// Disks are all the disks found, or only the one probed with WithDevice
+	Disks []DiskState `yaml:"disks,omitempty" json:"disks,omitempty"`
+	// ZFSPools are only found on nodes with the zfs tools installed
+	ZFSPools []ZFSPool `yaml:"zfs_pools,omitempty" json:"zfs_pools,omitempty"`
+	// LabelDevices lists every device carrying each of the DefaultLabels, on all the disks probed
+	LabelDevices map[string][]string `yaml:"label_devices,omitempty" json:"label_devices,omitempty"`
+	// Extra holds the partitions found by the detectors added with RegisterPartitionDetector, by label
+	Extra map[string]PartitionState `yaml:"extra,omitempty" json:"extra,omitempty"`
+	// Timings is only filled when probing with WithTimings
+	Timings map[string]time.Duration `yaml:"timings,omitempty" json:"timings,omitempty"`
+	// Partial is set when parts of the runtime were not probed, Warnings tells which and why
+	Partial  bool     `yaml:"partial" json:"partial"`
+	Warnings []string `yaml:"warnings,omitempty" json:"warnings,omitempty"`
+	// DetectionLog is only filled when probing with WithDetectionLog, it holds the raw output of each command run
+	DetectionLog map[string]string `yaml:"detection_log,omitempty" json:"detection_log,omitempty"`
+
+	fs     vfs.FS
+	runner CommandRunner
+	// lsblkPath and findmntPath are the tools the methods run, the defaults are used when unset
+	lsblkPath   string
+	findmntPath string
+	// cmdline holds the kernel parameters read while probing, see CmdlineParams
+	cmdline map[string]string
+	// queryMaxResults and queryMaxBytes limit the output of the queries, the defaults are used when unset
+	queryMaxResults int
+	queryMaxBytes   int
+}
+
+// WithFS returns a copy of the runtime whose methods read files through the given vfs so they can be used for tests as well
+func (r Runtime) WithFS(fs vfs.FS) Runtime {
+	r.fs = fs
+	return r
+}
+
+// WithCommandRunner returns a copy of the runtime whose methods call external tools through the given runner
+func (r Runtime) WithCommandRunner(runner CommandRunner) Runtime {
+	r.runner = runner
+	return r
+}
+
+// WithToolPaths returns a copy of the runtime whose methods run the given lsblk and findmnt, like WithLsblkPath and
+// WithFindmntPath do for the runtimes probed with them. Empty paths keep the defaults.
+func (r Runtime) WithToolPaths(lsblk, findmnt string) Runtime {
+	r.lsblkPath, r.findmntPath = lsblk, findmnt
+	return r
+}
+
+// WithQueryLimits returns a copy of the runtime whose queries fail with ErrQueryLimit once they emit more than
+// maxResults values or more than maxBytes of json. Zero or negative limits keep the defaults.
+func (r Runtime) WithQueryLimits(maxResults, maxBytes int) Runtime {
+	r.queryMaxResults, r.queryMaxBytes = maxResults, maxBytes
+	return r
+}
+
+func (r Runtime) commandRunner() CommandRunner {
+	if r.runner == nil {
+		return utils.SHContext
+	}
+	return r.runner
+}
+
+func (r Runtime) lsblk() string {
+	if r.lsblkPath == "" {
+		return defaultLsblk
+	}
+	return r.lsblkPath
+}
+
+func (r Runtime) findmnt() string {
+	if r.findmntPath == "" {
+		return defaultFindmnt
+	}
+	return r.findmntPath
+}
+
+func (r Runtime) filesystem() vfs.FS {
+	if r.fs == nil {
+		return vfs.OSFS
+	}
+	return r.fs
 }
 
 type FndMnt struct {
@@ -74,18 +262,38 @@ type Lsblk struct {
 	} `json:"blockdevices,omitempty"`
 }
 
-func detectPartitionByFindmnt(b *block.Partition) PartitionState {
+// detectPartitionByFindmnt fills the state of a partition found by ghw, looking up where it's mounted with findmnt.
+// The error is only set when findmnt failed for another reason than the partition not being mounted.
+func detectPartitionByFindmnt(ctx context.Context, runner CommandRunner, findmnt string, b *block.Partition) (PartitionState, error) {
 	// If mountpoint seems empty, try to get the mountpoint of the partition label also the RO status
 	// This is a current shortcoming of ghw which only identifies mountpoints via device, not by label/uuid/anything else
 	mountpoint := b.MountPoint
 	readOnly := b.IsReadOnly
-	if b.MountPoint == "" && b.FilesystemLabel != "" {
-		out, err := utils.SH(fmt.Sprintf("findmnt /dev/disk/by-label/%s -f -J -o TARGET,FS-OPTIONS", b.FilesystemLabel))
+	var otherMountpoints []string
+	var detectErr error
+	// Labels that wouldn't be safe to pass to the shell are skipped, they can't be a Kairos partition anyway
+	if b.MountPoint == "" && ValidateLabel(b.FilesystemLabel) == nil {
+		out, err := runner(ctx, fmt.Sprintf("%s /dev/disk/by-label/%s -l -J -o TARGET,FS-OPTIONS", findmnt, b.FilesystemLabel))
 		mnt := &FndMnt{}
+		// findmnt fails without a word when the partition is not mounted, anything else is a real failure
+		if err != nil && (strings.TrimSpace(out) != "" || ctx.Err() != nil) {
+			detectErr = fmt.Errorf("running findmnt: %w (%s)", err, strings.TrimSpace(out))
+		}
 		if err == nil {
 			err = json.Unmarshal([]byte(out), mnt)
+			if err != nil {
+				detectErr = fmt.Errorf("parsing the findmnt output: %w", err)
+			}
 			// This should not happen, if there were no targets, the command would have returned an error, but you never know...
-			if err == nil && len(mnt.Filesystems) == 1 {
+			if err == nil && len(mnt.Filesystems) > 0 {
+				// With bind mounts or btrfs subvolumes the partition is mounted in several places, go with the
+				// root-most one and keep the rest around
+				sort.SliceStable(mnt.Filesystems, func(i, j int) bool {
+					return rootMost(mnt.Filesystems[i].Target, mnt.Filesystems[j].Target)
+				})
+				for _, f := range mnt.Filesystems[1:] {
+					otherMountpoints = append(otherMountpoints, f.Target)
+				}
 				mountpoint = mnt.Filesystems[0].Target
 				// Don't assume its ro or rw by default, check both. One should match
 				regexRW := regexp.MustCompile("^rw,|^rw$|,rw,|,rw$")
@@ -100,180 +308,670 @@ func detectPartitionByFindmnt(b *block.Partition) PartitionState {
 		}
 	}
 	return PartitionState{
-		Type:            b.Type,
-		IsReadOnly:      readOnly,
-		UUID:            b.UUID,
-		Name:            fmt.Sprintf("/dev/%s", b.Name),
-		SizeBytes:       b.SizeBytes,
-		Label:           b.Label,
-		FilesystemLabel: b.FilesystemLabel,
-		MountPoint:      mountpoint,
-		Mounted:         mountpoint != "",
-		Found:           true,
+		Type:             b.Type,
+		IsReadOnly:       readOnly,
+		UUID:             b.UUID,
+		Name:             fmt.Sprintf("/dev/%s", b.Name),
+		SizeBytes:        b.SizeBytes,
+		Label:            b.Label,
+		FilesystemLabel:  b.FilesystemLabel,
+		MountPoint:       mountpoint,
+		OtherMountPoints: otherMountpoints,
+		Mounted:          mountpoint != "",
+		Found:            true,
+	}, detectErr
+}
+
+// rootMost returns whether path a is closer to the root than path b, the shortest one winning between paths as deep
+func rootMost(a, b string) bool {
+	depthA, depthB := strings.Count(strings.TrimSuffix(a, "/"), "/"), strings.Count(strings.TrimSuffix(b, "/"), "/")
+	if depthA != depthB {
+		return depthA < depthB
 	}
+	return len(a) < len(b)
+}
+
+// DetectBoot returns the boot state of the running system from its cmdline, without probing anything else
+func DetectBoot() (Boot, error) {
+	return DetectBootWithVFS(vfs.OSFS)
 }
 
-func detectBoot() Boot {
-	cmdline, err := os.ReadFile("/proc/cmdline")
+// DetectBootWithVFS will detect the boot state using a vfs so it can be used for tests as well
+func DetectBootWithVFS(fs types.KairosFS) (Boot, error) {
+	cmdline, err := fs.ReadFile("/proc/cmdline")
 	if err != nil {
-		return Unknown
+		return Unknown, err
 	}
-	cmdlineS := string(cmdline)
+	return bootFromParams(parseCmdline(string(cmdline))), nil
+}
+
+// bootFromParams tells the boot state from the root the kernel parameters point to, and the reset and netboot flags
+func bootFromParams(params map[string]string) Boot {
+	root := strings.TrimPrefix(params["root"], "LABEL=")
+	_, reset := params["kairos.reset"]
+	_, netboot := params["netboot"]
 	switch {
-	case strings.Contains(cmdlineS, "COS_ACTIVE"):
+	case root == "COS_ACTIVE":
 		return Active
-	case strings.Contains(cmdlineS, "COS_PASSIVE"):
+	case root == "COS_PASSIVE":
 		return Passive
-	case strings.Contains(cmdlineS, "COS_RECOVERY"), strings.Contains(cmdlineS, "COS_SYSTEM"):
+	// Reset boots into the recovery system, so it has to be checked before recovery
+	case reset:
+		return Reset
+	case root == "COS_RECOVERY", root == "COS_SYSTEM":
 		return Recovery
-	case strings.Contains(cmdlineS, "live:LABEL"), strings.Contains(cmdlineS, "live:CDLABEL"), strings.Contains(cmdlineS, "netboot"):
+	case strings.HasPrefix(root, "live:LABEL="), strings.HasPrefix(root, "live:CDLABEL="), netboot:
 		return LiveCD
 	default:
 		return Unknown
 	}
 }
 
-// DetectBootWithVFS will detect the boot state using a vfs so it can be used for tests as well
-func DetectBootWithVFS(fs types.KairosFS) (Boot, error) {
-	cmdline, err := fs.ReadFile("/proc/cmdline")
-	if err != nil {
-		return Unknown, err
-	}
-	cmdlineS := string(cmdline)
+// DetectBootloaderWithVFS will detect the bootloader in use by looking for its files using a vfs so it can be used for tests as well
+func DetectBootloaderWithVFS(fs vfs.FS) string {
 	switch {
-	case strings.Contains(cmdlineS, "COS_ACTIVE"):
-		return Active, nil
-	case strings.Contains(cmdlineS, "COS_PASSIVE"):
-		return Passive, nil
-	case strings.Contains(cmdlineS, "COS_RECOVERY"), strings.Contains(cmdlineS, "COS_SYSTEM"):
-		return Recovery, nil
-	case strings.Contains(cmdlineS, "live:LABEL"), strings.Contains(cmdlineS, "live:CDLABEL"), strings.Contains(cmdlineS, "netboot"):
-		return LiveCD, nil
+	case exists(fs, "/boot/grub2"):
+		return BootloaderGrub
+	case exists(fs, "/boot/loader/entries"):
+		return BootloaderSystemdBoot
+	case exists(fs, "/boot/boot.scr"), exists(fs, "/boot/uEnv.txt"):
+		return BootloaderUBoot
 	default:
-		return Unknown, nil
+		return BootloaderUnknown
 	}
 }
 
-func detectRuntimeState(r *Runtime) error {
-	blockDevices, err := block.New(ghw.WithDisableTools(), ghw.WithDisableWarnings())
-	// ghw currently only detects if partitions are mounted via the device
-	// If we mount them via label, then its set as not mounted.
+func exists(fs vfs.FS, path string) bool {
+	_, err := fs.Stat(path)
+	return err == nil
+}
+
+func detectRuntimeState(ctx context.Context, r *Runtime, o *Options) error {
+	ghwOpts := []*ghw.WithOption{ghw.WithDisableTools(), ghw.WithDisableWarnings()}
+	if o.ChrootPath != "" {
+		ghwOpts = append(ghwOpts, ghw.WithChroot(o.ChrootPath))
+	}
+	stop := o.track(r, "ghw")
+	blockDevices, err := o.blockDevices(ghwOpts...)
+	stop()
+	// oem and recovery can be on LVM which ghw doesn't see, when ghw fails altogether lsblk is left for all of them
+	lsblkKeys := []string{"oem", "recovery"}
 	if err != nil {
-		return err
+		r.warn("ghw failed, partitions were only looked up with lsblk: %s", err)
+		blockDevices = &block.Info{}
+		lsblkKeys = defaultLabelKeys()
 	}
+	// ghw currently only detects if partitions are mounted via the device
+	// If we mount them via label, then its set as not mounted.
+	fields := r.partitionFields()
+	disks := []*block.Disk{}
+	totalPartitions := 0
 	for _, d := range blockDevices.Disks {
+		if o.onDevice(fmt.Sprintf("/dev/%s", d.Name)) {
+			disks = append(disks, d)
+			totalPartitions += len(d.Partitions)
+		}
+	}
+	o.progress("disks", 0, len(disks))
+	donePartitions := 0
+	for i, d := range disks {
+		r.Disks = append(r.Disks, diskState(o.FS, d))
 		for _, part := range d.Partitions {
-			switch part.FilesystemLabel {
-			case "COS_PERSISTENT":
-				r.Persistent = detectPartitionByFindmnt(part)
-			case "COS_RECOVERY":
-				r.Recovery = detectPartitionByFindmnt(part)
-			case "COS_OEM":
-				r.OEM = detectPartitionByFindmnt(part)
-			case "COS_STATE":
-				r.State = detectPartitionByFindmnt(part)
+			for key, label := range DefaultLabels {
+				if label == "" || part.FilesystemLabel != label {
+					continue
+				}
+				if r.LabelDevices == nil {
+					r.LabelDevices = map[string][]string{}
+				}
+				r.LabelDevices[label] = append(r.LabelDevices[label], fmt.Sprintf("/dev/%s", part.Name))
+				stop := o.track(r, "findmnt/"+label)
+				p, err := detectPartitionByFindmnt(ctx, o.Runner, o.FindmntPath, part)
+				stop()
+				if err != nil && o.FailFast {
+					return fmt.Errorf("detecting the %s partition: %w", label, err)
+				}
+				if target, ok := fields[key]; ok {
+					*target = p
+					continue
+				}
+				if r.Extra == nil {
+					r.Extra = map[string]PartitionState{}
+				}
+				r.Extra[key] = p
 			}
+			donePartitions++
+			o.progress("partitions", donePartitions, totalPartitions)
 		}
+		o.progress("disks", i+1, len(disks))
 	}
-	if !r.OEM.Found {
-		r.OEM = detectPartitionByLsblk("COS_OEM")
+	if err := detectPartitionsByLsblk(ctx, r, o, lsblkKeys); err != nil {
+		return err
+	}
+	for _, p := range []*PartitionState{&r.Persistent, &r.Recovery, &r.OEM, &r.State} {
+		canonicalizeDevice(o.FS, p)
+		if p.Found {
+			p.Encrypted, p.UnlockMethod = DetectEncryptionWithVFS(o.FS, p.Name)
+			p.StartOffsetBytes, p.Aligned = PartitionAlignmentWithVFS(o.FS, p.Name)
+			p.DeviceChain = DeviceChainWithVFS(o.FS, p.Name)
+		}
+	}
+	detectPropagation(o.FS, &r.Persistent, &r.Recovery, &r.OEM, &r.State)
+	r.OEM.Role = DetectOEMRoleWithVFS(o.FS, r.OEM)
+	for _, p := range []*PartitionState{&r.Persistent, &r.Recovery, &r.OEM, &r.State} {
+		// dumpe2fs reads the device itself, which needs root
+		if !o.NoRoot {
+			detectFilesystemState(ctx, o.Runner, p)
+		}
+		p.RemountedReadOnly = DetectRemountedReadOnlyWithVFS(o.FS, *p)
+	}
+	if efi, ok := r.Extra[EFIPartitionKey]; ok && efi.Mounted {
+		efi.UsedBytes, efi.FreeBytes, _ = filesystemUsage(o.FS, efi.MountPoint)
+		r.Extra[EFIPartitionKey] = efi
+	}
+	detectExtraPartitions(r, o)
+	return nil
+}
+
+// partitionFields maps the DefaultLabels keys to the partitions of the runtime, the others go to Extra
+func (r *Runtime) partitionFields() map[string]*PartitionState {
+	return map[string]*PartitionState{"persistent": &r.Persistent, "recovery": &r.Recovery, "oem": &r.OEM, "state": &r.State}
+}
+
+// warn records a problem that left the runtime partially probed
+func (r *Runtime) warn(format string, args ...interface{}) {
+	r.Partial = true
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// defaultLabelKeys returns the keys of DefaultLabels, sorted
+func defaultLabelKeys() []string {
+	keys := []string{}
+	for key := range DefaultLabels {
+		keys = append(keys, key)
 	}
-	if !r.Recovery.Found {
-		r.Recovery = detectPartitionByLsblk("COS_RECOVERY")
+	sort.Strings(keys)
+	return keys
+}
+
+// detectPartitionsByLsblk looks up with lsblk the partitions of the given DefaultLabels keys that were not found yet.
+// lsblk failures are only returned with FailFast, and stop the lookup.
+func detectPartitionsByLsblk(ctx context.Context, r *Runtime, o *Options, keys []string) error {
+	fields := r.partitionFields()
+	for _, key := range keys {
+		label := DefaultLabels[key]
+		target, ok := fields[key]
+		if label == "" || (ok && target.Found) || (!ok && r.Extra[key].Found) {
+			continue
+		}
+		stop := o.track(r, "lsblk/"+label)
+		p, err := detectPartitionByLsblk(ctx, o.Runner, o.LsblkPath, label)
+		stop()
+		if err != nil && o.FailFast {
+			return fmt.Errorf("detecting the %s partition: %w", label, err)
+		}
+		if !p.Found || !o.onDevice(p.Name) {
+			continue
+		}
+		if ok {
+			*target = p
+			continue
+		}
+		if r.Extra == nil {
+			r.Extra = map[string]PartitionState{}
+		}
+		r.Extra[key] = p
 	}
 	return nil
 }
 
 // detectPartitionByLsblk will try to detect info about a partition by using lsblk
 // Useful for LVM partitions which ghw is unable to find
-func detectPartitionByLsblk(label string) PartitionState {
-	out, err := utils.SH(fmt.Sprintf("lsblk /dev/disk/by-label/%s -o PATH,FSTYPE,MOUNTPOINT,SIZE,RO,LABEL -J", label))
-	mnt := &Lsblk{}
+func detectPartitionByLsblk(ctx context.Context, runner CommandRunner, lsblk, label string) (PartitionState, error) {
+	if ValidateLabel(label) != nil {
+		return PartitionState{}, nil
+	}
+	return lsblkPartition(ctx, runner, lsblk, fmt.Sprintf("/dev/disk/by-label/%s", label))
+}
+
+// lsblkPartition fills the state of the partition at the given device path with the output of lsblk.
+// lsblk refusing the path as not being a block device only means there is no such partition, the error is only set
+// when lsblk failed for another reason.
+func lsblkPartition(ctx context.Context, runner CommandRunner, lsblk, device string) (PartitionState, error) {
+	out, err := runner(ctx, fmt.Sprintf("%s %s -o PATH,FSTYPE,MOUNTPOINT,SIZE,RO,LABEL -J", lsblk, device))
 	part := PartitionState{}
-	if err == nil {
-		err = json.Unmarshal([]byte(out), mnt)
-		// This should not happen, if there were no targets, the command would have returned an error, but you never know...
-		if err == nil && len(mnt.BlockDevices) == 1 {
-			blk := mnt.BlockDevices[0]
-			part.Found = true
-			part.Name = blk.Path
-			part.Mounted = blk.Mountpoint != ""
-			part.MountPoint = blk.Mountpoint
-			part.Type = blk.FsType
-			part.FilesystemLabel = blk.Label
-			// this seems to report always false. We can try to use findmnt here to know if its ro/rw
-			part.IsReadOnly = blk.RO
+	if err != nil {
+		if ctx.Err() == nil && strings.Contains(out, "not a block device") {
+			return part, nil
 		}
+		return part, fmt.Errorf("running lsblk on %s: %w (%s)", device, err, strings.TrimSpace(out))
 	}
-
-	return part
+	mnt := &Lsblk{}
+	if err = json.Unmarshal([]byte(out), mnt); err != nil {
+		return part, fmt.Errorf("parsing the lsblk output: %w", err)
+	}
+	// This should not happen, if there were no targets, the command would have returned an error, but you never know...
+	if len(mnt.BlockDevices) == 1 {
+		blk := mnt.BlockDevices[0]
+		part.Found = true
+		part.Name = blk.Path
+		part.Mounted = blk.Mountpoint != ""
+		part.MountPoint = blk.Mountpoint
+		part.Type = blk.FsType
+		part.FilesystemLabel = blk.Label
+		// this seems to report always false. We can try to use findmnt here to know if its ro/rw
+		part.IsReadOnly = blk.RO
+	}
+	return part, nil
 }
 
-func detectSystem(r *Runtime) {
+// hostSystemInfo reads the hardware of the running system with sysinfo
+func hostSystemInfo() SystemInfo {
 	var si sysinfo.SysInfo
 
 	si.GetSysInfo()
-	r.System = si
+	return systemInfoFrom(si)
 }
 
-func detectKairos(r *Runtime) {
+func detectKairos(r *Runtime, o *Options) {
 	k := &Kairos{}
-	k.Flavor = utils.Flavor()
-
-	v, err := utils.OSRelease("VERSION")
+	release, err := readOSRelease(o.FS, o.OSReleasePath)
 	if err == nil {
-		k.Version = v
+		k.Flavor, _ = osReleaseValue(release, "FLAVOR")
+		k.Version, _ = osReleaseValue(release, "VERSION")
 	}
 	r.Kairos = *k
 }
 
+// readOSRelease parses an os-release file through the vfs
+func readOSRelease(fs vfs.FS, path string) (map[string]string, error) {
+	dat, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return godotenv.Unmarshal(string(dat))
+}
+
+// osReleaseValue looks up a key like utils.OSRelease does, preferring the KAIROS_ prefixed one
+func osReleaseValue(release map[string]string, key string) (string, error) {
+	if v, exists := release["KAIROS_"+key]; exists {
+		return v, nil
+	}
+	// We try with the old naming without the prefix in case the key wasn't found
+	if v, exists := release[key]; exists {
+		return v, nil
+	}
+	return "", fmt.Errorf("key not found")
+}
+
 func NewRuntime() (Runtime, error) {
+	return NewRuntimeWithOptions()
+}
+
+// NewRuntimeWithOptions probes the system like NewRuntime, with the given options applied
+func NewRuntimeWithOptions(opts ...Option) (Runtime, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return Runtime{}, err
+	}
+	return probe(context.Background(), o, true)
+}
+
+// NewRuntimeWithProgress probes the system like NewRuntimeWithOptions, calling progress as it goes through the
+// disks and partitions. The probe stops running external tools once the context is done.
+func NewRuntimeWithProgress(ctx context.Context, progress ProgressFunc, opts ...Option) (Runtime, error) {
+	o, err := newOptions(append(opts, WithProgress(progress))...)
+	if err != nil {
+		return Runtime{}, err
+	}
+	return probe(ctx, o, true)
+}
+
+// NewRuntimeWithDeadline probes the system like NewRuntimeWithOptions, but gives up after d. It then returns what
+// was gathered until then, along with context.DeadlineExceeded, even if a stage is stuck on something that can't
+// be interrupted, like a hung disk. The stages that were not probed stay zero.
+func NewRuntimeWithDeadline(d time.Duration, opts ...Option) (Runtime, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return Runtime{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	var mu sync.Mutex
+	var partial Runtime
+	o.checkpoint = func(r Runtime) {
+		mu.Lock()
+		defer mu.Unlock()
+		partial = r
+	}
+	type result struct {
+		r   Runtime
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := probe(ctx, o, true)
+		done <- result{r, err}
+	}()
+	select {
+	case res := <-done:
+		return res.r, res.err
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		return partial, ctx.Err()
+	}
+}
+
+// probe does the actual probing, sysinfo can be skipped as it's by far the most expensive part
+func probe(ctx context.Context, o *Options, withSystem bool) (Runtime, error) {
+	// the cmdline is only read once, everything looking at the kernel parameters is given these
+	params := readCmdlineParams(o.FS)
 	runtime := &Runtime{
-		BootState: detectBoot(),
-		UUID:      utils.UUID(),
+		BootState:   bootFromParams(params),
+		Bootloader:  DetectBootloaderWithVFS(o.FS),
+		Init:        DetectInitWithVFS(o.FS),
+		UUID:        utils.UUID(),
+		fs:          o.FS,
+		runner:      o.Runner,
+		lsblkPath:   o.LsblkPath,
+		findmntPath: o.FindmntPath,
+		cmdline:     params,
+	}
+	if o.StrictBoot && runtime.BootState == Unknown {
+		return *runtime, ErrUnknownBoot
+	}
+	if err := o.checkTools(); err != nil {
+		return *runtime, err
+	}
+
+	if o.DetectionLog {
+		o = o.logCommands(runtime)
+	}
+
+	if o.NoRoot {
+		runtime.warn("probed without root, sysinfo and the filesystem checks were skipped")
+	}
+
+	// checkpoint hands what was gathered so far to NewRuntimeWithDeadline, and tells whether to go on
+	checkpoint := func() bool {
+		if o.checkpoint != nil {
+			o.checkpoint(runtime.Clone())
+		}
+		return ctx.Err() == nil
+	}
+
+	runtime.Uptime, runtime.BootTime, _ = DetectUptimeWithVFS(o.FS)
+	runtime.EFIBootOrder, runtime.EFICurrent = DetectEFIBootWithVFS(o.FS)
+	runtime.BootAttemptsLeft, runtime.BootAttemptsMax = DetectBootCountersWithVFS(o.FS)
+	runtime.DegradedBoot = degradedBoot(o.FS, params)
+	runtime.Immutable = DetectImmutableWithVFS(o.FS)
+	runtime.CPUCount = CPUCountWithVFS(o.FS)
+	runtime.MemoryBytes, _ = TotalMemoryBytesWithVFS(o.FS)
+	runtime.StorageDrivers = StorageDriversWithVFS(o.FS)
+	runtime.Timezone = DetectTimezoneWithVFS(o.FS)
+	runtime.Locale = DetectLocaleWithVFS(o.FS)
+	if runtime.Locale == "" && o.ChrootPath == "" {
+		// the environment is only the one of the system when it's not probed from a rescue one
+		runtime.Locale = os.Getenv("LANG")
+	}
+	if !checkpoint() {
+		return *runtime, ctx.Err()
 	}
 
-	detectSystem(runtime)
-	detectKairos(runtime)
-	err := detectRuntimeState(runtime)
+	stop := o.track(runtime, "kairos")
+	detectKairos(runtime, o)
+	stop()
+	o.progress("kairos", 1, 1)
+	if !checkpoint() {
+		return *runtime, ctx.Err()
+	}
+
+	stop = o.track(runtime, "network")
+	detectNetwork(runtime, o)
+	stop()
+	if !checkpoint() {
+		return *runtime, ctx.Err()
+	}
+
+	stop = o.track(runtime, "cluster")
+	detectCluster(ctx, runtime, o)
+	stop()
+	if !checkpoint() {
+		return *runtime, ctx.Err()
+	}
+
+	// Partitions and hardware seen from a container are the host ones, if any, so don't bother
+	if runtime.InContainer() {
+		return *runtime, nil
+	}
+
+	if withSystem && !o.NoRoot {
+		o.progress("sysinfo", 0, 1)
+		stop = o.track(runtime, "sysinfo")
+		runtime.System = o.systemInfo()
+		stop()
+		o.progress("sysinfo", 1, 1)
+	}
+	if !checkpoint() {
+		return *runtime, ctx.Err()
+	}
+	err := detectRuntimeState(ctx, runtime, o)
+
+	runtime.BootConfigProtected = runtime.bootConfigProtected()
+	if o.IOLatency {
+		measureIOLatency(o.FS, &runtime.Persistent)
+	}
+	if !checkpoint() {
+		return *runtime, ctx.Err()
+	}
+
+	stop = o.track(runtime, "zfs")
+	detectZFS(ctx, runtime, o)
+	stop()
+	checkpoint()
 
 	return *runtime, err
 }
 
 func (r Runtime) String() string {
-	dat, err := yaml.Marshal(r)
+	dat, err := r.YAML(false)
 	if err == nil {
 		return string(dat)
 	}
 	return ""
 }
 
+// YAML serializes the runtime, in the same block style as String() or, when compact is set, using flow style
+// for the maps that only hold scalars (like the partitions), which makes for much smaller dumps.
+func (r Runtime) YAML(compact bool) ([]byte, error) {
+	if !compact {
+		return yaml.Marshal(r)
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(r); err != nil {
+		return nil, err
+	}
+	flowSimpleMaps(node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// JSON serializes the runtime to the same document Query runs against, indented with 2 spaces when indent is set
+// for people to read, or compact for machines
+func (r Runtime) JSON(indent bool) ([]byte, error) {
+	if indent {
+		return json.MarshalIndent(r, "", "  ")
+	}
+	return json.Marshal(r)
+}
+
+// flowSimpleMaps switches to flow style all the mappings in the tree whose values are all scalars
+func flowSimpleMaps(n *yaml.Node) {
+	simple := n.Kind == yaml.MappingNode && len(n.Content) > 0
+	for i, c := range n.Content {
+		flowSimpleMaps(c)
+		// Mapping contents alternate key and value, only values can be something else than scalars.
+		// Timestamps are left alone as they can't be plain in flow style and would be read back as strings.
+		if n.Kind == yaml.MappingNode && i%2 == 1 && (c.Kind != yaml.ScalarNode || c.ShortTag() == "!!timestamp") {
+			simple = false
+		}
+	}
+	if simple {
+		n.Style = yaml.FlowStyle
+	}
+}
+
 func (r Runtime) Query(s string) (res string, err error) {
-	s = fmt.Sprintf(".%s", s)
+	return r.QueryWithContext(context.Background(), s)
+}
+
+// QueryWithContext is like Query but stops evaluating the expression once the context is done,
+// returning what was gathered until then along with the context error
+func (r Runtime) QueryWithContext(ctx context.Context, s string) (res string, err error) {
+	err = r.runQuery(ctx, s, func(v interface{}) {
+		res += fmt.Sprint(v)
+	})
+	return
+}
+
+// QueryAll is like Query but returns every value emitted by the expression separately.
+// The results are always in the same order for the same runtime: arrays are walked in order,
+// objects in the lexical order of their keys, and maps inside a result are printed with sorted keys.
+func (r Runtime) QueryAll(s string) (res []string, err error) {
+	err = r.runQuery(context.Background(), s, func(v interface{}) {
+		res = append(res, fmt.Sprint(v))
+	})
+	return
+}
+
+// QueryValue is like Query but returns the result as its native type as decoded from json (float64, bool, string,
+// []interface{} or map[string]interface{}) instead of stringifying it. When the expression emits several values,
+// they are all returned in a []interface{}, and nil is returned if it emits none.
+func (r Runtime) QueryValue(s string) (interface{}, error) {
+	var res []interface{}
+	err := r.runQuery(context.Background(), s, func(v interface{}) {
+		res = append(res, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch len(res) {
+	case 0:
+		return nil, nil
+	case 1:
+		return res[0], nil
+	default:
+		return res, nil
+	}
+}
+
+// runQuery runs the jq expression against the json encoding of the runtime, calling emit with each value in order.
+// It stops with ErrQueryLimit once the values go over the limits of the runtime.
+func (r Runtime) runQuery(ctx context.Context, s string, emit func(v interface{})) error {
 	jsondata := map[string]interface{}{}
-	var dat []byte
-	dat, err = json.Marshal(r)
+	dat, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(dat, &jsondata)
 	if err != nil {
-		return
+		return err
+	}
+	return evalQuery(ctx, fmt.Sprintf(".%s", s), jsondata, r.queryMaxResults, r.queryMaxBytes, emit)
+}
+
+// QueryRuntimes runs a jq expression against all the runtimes at once, like jq -s, so they can be aggregated
+// with expressions like `[.[] | .kairos.version] | unique`. Unlike Query, the expression is taken as is, the
+// runtimes are the array it starts from. The results are joined like Query does, with the default limits.
+func QueryRuntimes(rs []Runtime, s string) (res string, err error) {
+	jsondata := []interface{}{}
+	dat, err := json.Marshal(rs)
+	if err != nil {
+		return "", err
 	}
 	err = json.Unmarshal(dat, &jsondata)
 	if err != nil {
-		return
+		return "", err
 	}
+	err = evalQuery(context.Background(), s, jsondata, 0, 0, func(v interface{}) {
+		res += fmt.Sprint(v)
+	})
+	return
+}
+
+// evalQuery runs the jq expression against the data, calling emit with each value in order, and stops with
+// ErrQueryLimit once they go over the given limits, or the default ones when unset.
+// Callers query the runtime whether the probe succeeded or not, so a panic evaluating it is turned into an error.
+func evalQuery(ctx context.Context, s string, jsondata interface{}, maxResults, maxBytes int, emit func(v interface{})) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("evaluating %q: %v", s, p)
+		}
+	}()
 	query, err := gojq.Parse(s)
 	if err != nil {
-		return res, err
+		return err
+	}
+	if maxResults <= 0 {
+		maxResults = DefaultQueryMaxResults
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultQueryMaxBytes
 	}
-	iter := query.Run(jsondata) // or query.RunWithContext
+	results, size := 0, 0
+	iter := query.RunWithContext(ctx, jsondata)
 	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		v, ok := iter.Next()
 		if !ok {
 			break
 		}
 		if err, ok := v.(error); ok {
-			return res, err
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
 		}
-		res += fmt.Sprint(v)
+		results++
+		if results > maxResults {
+			return fmt.Errorf("%w: more than %d results", ErrQueryLimit, maxResults)
+		}
+		out, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		size += len(out)
+		if size > maxBytes {
+			return fmt.Errorf("%w: more than %d bytes", ErrQueryLimit, maxBytes)
+		}
+		emit(v)
+	}
+	return nil
+}
+
+// IsInstalled returns whether there is a Kairos installation on disk, as opposed to only running from the
+// install media. It errors out when the boot state and the partitions found don't agree.
+func (r Runtime) IsInstalled() (bool, error) {
+	switch {
+	case r.State.Found:
+		return true, nil
+	case r.BootState == LiveCD:
+		return false, nil
+	case r.BootState == Unknown || r.BootState == "":
+		return false, fmt.Errorf("could not find the state partition and the boot state is unknown")
+	default:
+		return false, fmt.Errorf("booted from %s but the state partition could not be found", r.BootState)
 	}
-	return
 }
@@ -0,0 +1,400 @@
+package state_test
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Runtime", func() {
+	var r Runtime
+
+	BeforeEach(func() {
+		r = Runtime{
+			UUID:      "uuid",
+			BootState: Active,
+			Persistent: PartitionState{
+				Found:           true,
+				Mounted:         true,
+				Name:            "/dev/sda5",
+				FilesystemLabel: "COS_PERSISTENT",
+				MountPoint:      "/usr/local",
+				SizeBytes:       1024,
+			},
+			Kairos: Kairos{Flavor: "opensuse", Version: "v2.3.0"},
+		}
+	})
+
+	Describe("YAML", func() {
+		It("matches String() in block style", func() {
+			dat, err := r.YAML(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(dat)).To(Equal(r.String()))
+		})
+
+		It("uses flow style for simple maps when compact", func() {
+			dat, err := r.YAML(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(dat)).To(ContainSubstring("persistent: {"))
+			Expect(string(dat)).To(ContainSubstring("kairos: {flavor: opensuse, version: v2.3.0}"))
+			Expect(len(dat)).To(BeNumerically("<", len(r.String())))
+		})
+
+		It("produces the same document in both styles", func() {
+			block, err := r.YAML(false)
+			Expect(err).ToNot(HaveOccurred())
+			compact, err := r.YAML(true)
+			Expect(err).ToNot(HaveOccurred())
+
+			fromBlock := map[string]interface{}{}
+			fromCompact := map[string]interface{}{}
+			Expect(yaml.Unmarshal(block, &fromBlock)).To(Succeed())
+			Expect(yaml.Unmarshal(compact, &fromCompact)).To(Succeed())
+			Expect(fromCompact).To(Equal(fromBlock))
+		})
+	})
+
+	Describe("JSON", func() {
+		It("is compact unless indented", func() {
+			compact, err := r.JSON(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(compact)).To(HavePrefix(`{"uuid":"uuid","persistent":{`))
+			Expect(string(compact)).ToNot(ContainSubstring("\n"))
+
+			indented, err := r.JSON(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(indented)).To(HavePrefix("{\n  \"uuid\": \"uuid\",\n  \"persistent\": {\n    \""))
+			var fromCompact, fromIndented interface{}
+			Expect(json.Unmarshal(compact, &fromCompact)).To(Succeed())
+			Expect(json.Unmarshal(indented, &fromIndented)).To(Succeed())
+			Expect(fromIndented).To(Equal(fromCompact))
+		})
+
+		It("is the document queries run against", func() {
+			dat, err := r.JSON(false)
+			Expect(err).ToNot(HaveOccurred())
+			doc := map[string]interface{}{}
+			Expect(json.Unmarshal(dat, &doc)).To(Succeed())
+			v, err := r.QueryValue("persistent")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal(doc["persistent"]))
+		})
+	})
+
+	Describe("Encode", func() {
+		It("round trips through Decode", func() {
+			r.Timings = map[string]time.Duration{"ghw": time.Second}
+			r.System = SystemInfo{
+				OS:     SystemOS{Name: "openSUSE Leap", Version: "15.5"},
+				Kernel: SystemKernel{Release: "5.14.21"},
+				CPU:    SystemCPU{Vendor: "GenuineIntel", Cpus: 1, Cores: 4},
+				Memory: SystemMemory{Size: 8192},
+			}
+
+			dat, err := r.Encode()
+			Expect(err).ToNot(HaveOccurred())
+			decoded, err := Decode(dat)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal(r))
+		})
+
+		It("fails to decode garbage", func() {
+			_, err := Decode([]byte("not a runtime"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("MarshalText", func() {
+		It("matches String()", func() {
+			dat, err := r.MarshalText()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(dat)).To(Equal(r.String()))
+		})
+
+		It("round trips through UnmarshalText", func() {
+			dat, err := r.MarshalText()
+			Expect(err).ToNot(HaveOccurred())
+			decoded := Runtime{}
+			Expect(decoded.UnmarshalText(dat)).To(Succeed())
+			Expect(decoded).To(Equal(r))
+		})
+
+		It("doesn't change the json encoding", func() {
+			dat, err := json.Marshal(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(dat)).To(HavePrefix(`{"uuid":"uuid",`))
+			decoded := Runtime{}
+			Expect(json.Unmarshal(dat, &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(r))
+		})
+	})
+
+	Describe("Query", func() {
+		It("returns the value of the given path", func() {
+			res, err := r.Query("persistent.mount_point")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("/usr/local"))
+		})
+
+		It("returns the context error when cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := r.QueryWithContext(ctx, "persistent.mount_point")
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("stops evaluating when the deadline is hit", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := r.QueryWithContext(ctx, "uuid | last(repeat(.))")
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	Describe("query limits", func() {
+		It("fails when there are too many results", func() {
+			_, err := r.WithQueryLimits(2, 0).QueryAll("persistent[]")
+			Expect(err).To(MatchError(ErrQueryLimit))
+		})
+
+		It("fails when the output is too large", func() {
+			_, err := r.WithQueryLimits(0, 16).Query("persistent")
+			Expect(err).To(MatchError(ErrQueryLimit))
+		})
+
+		It("stops runaway expressions with the default limits", func() {
+			_, err := r.Query("uuid | repeat(.)")
+			Expect(err).To(MatchError(ErrQueryLimit))
+		})
+
+		It("allows results within the limits", func() {
+			res, err := r.WithQueryLimits(1, 16).Query("persistent.mount_point")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("/usr/local"))
+		})
+	})
+
+	Describe("a zero value runtime", func() {
+		It("can be queried", func() {
+			zero := Runtime{}
+			res, err := zero.Query("persistent.mount_point")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(BeEmpty())
+
+			all, err := zero.QueryAll("extra[]?")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(all).To(BeEmpty())
+
+			v, err := zero.QueryValue("system.cpu.model")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(BeNil())
+		})
+
+		It("can be serialized", func() {
+			zero := Runtime{}
+			Expect(zero.String()).ToNot(BeEmpty())
+			_, err := zero.YAML(true)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("QueryAll", func() {
+		It("returns every value separately", func() {
+			res, err := r.QueryAll("persistent.mount_point, .kairos.flavor")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal([]string{"/usr/local", "opensuse"}))
+		})
+
+		It("returns object values ordered by key", func() {
+			r.Extra = map[string]PartitionState{
+				"zeta":  {Name: "/dev/sdb1"},
+				"alpha": {Name: "/dev/sdb2"},
+				"mid":   {Name: "/dev/sdb3"},
+			}
+			for i := 0; i < 10; i++ {
+				res, err := r.QueryAll("extra[].name")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res).To(Equal([]string{"/dev/sdb2", "/dev/sdb3", "/dev/sdb1"}))
+			}
+		})
+
+		It("returns no results for an empty output", func() {
+			res, err := r.QueryAll("extra[]?")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(BeEmpty())
+		})
+	})
+
+	Describe("QueryValue", func() {
+		It("returns native values", func() {
+			v, err := r.QueryValue("persistent.mounted")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal(true))
+
+			v, err = r.QueryValue("persistent.size_bytes")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(BeNumerically("==", 1024))
+
+			v, err = r.QueryValue("kairos")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(HaveKeyWithValue("flavor", "opensuse"))
+		})
+
+		It("returns a slice for multiple results", func() {
+			v, err := r.QueryValue("persistent.mount_point, .kairos.flavor")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal([]interface{}{"/usr/local", "opensuse"}))
+		})
+
+		It("returns nil without results", func() {
+			v, err := r.QueryValue("extra[]?")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(BeNil())
+		})
+	})
+
+	Describe("QueryRuntimes", func() {
+		It("runs the expression against all the runtimes", func() {
+			other := r.Clone()
+			other.Kairos.Version = "v2.5.0"
+			res, err := QueryRuntimes([]Runtime{r, other, r}, "[.[] | .kairos.version] | unique | join(\",\")")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal(r.Kairos.Version + ",v2.5.0"))
+
+			res, err = QueryRuntimes([]Runtime{r, other}, "length")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("2"))
+		})
+
+		It("fails with invalid expressions", func() {
+			_, err := QueryRuntimes([]Runtime{r}, "[.[] |")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Flatten", func() {
+		It("uses the query paths as keys", func() {
+			r.Disks = []DiskState{{Name: "/dev/sda", SizeBytes: 107374182400}}
+			r.LabelDevices = map[string][]string{"COS-DATA": {"/dev/sdb1"}}
+			flat := r.Flatten()
+			Expect(flat).To(HaveKeyWithValue("persistent.mounted", "true"))
+			Expect(flat).To(HaveKeyWithValue("persistent.size_bytes", "1024"))
+			Expect(flat).To(HaveKeyWithValue("kairos.flavor", "opensuse"))
+			Expect(flat).To(HaveKeyWithValue("disks[0].size_bytes", "107374182400"))
+			Expect(flat).To(HaveKeyWithValue(`label_devices["COS-DATA"][0]`, "/dev/sdb1"))
+			Expect(flat).ToNot(HaveKey("system.product"))
+
+			// Query prints big numbers in exponent form, so only check that every key points to the value
+			for key, value := range flat {
+				res, err := r.Query(key)
+				Expect(err).ToNot(HaveOccurred())
+				if value != "" {
+					Expect(res).ToNot(Equal("<nil>"), key)
+				}
+			}
+		})
+	})
+
+	Describe("Diff", func() {
+		It("is empty for the same runtime", func() {
+			Expect(r.Diff(r.Clone())).To(BeEmpty())
+		})
+
+		It("returns the sorted paths that changed", func() {
+			other := r.Clone()
+			other.Persistent.Mounted = false
+			other.Kairos.Version = "v2.4.0"
+			other.Disks = []DiskState{{Name: "/dev/sda"}}
+			Expect(r.Diff(other)).To(Equal([]string{
+				"disks[0].aligned",
+				"disks[0].allocated",
+				"disks[0].hotplug",
+				"disks[0].logical_sector_size",
+				"disks[0].name",
+				"disks[0].partition_table",
+				"disks[0].physical_sector_size",
+				"disks[0].removable",
+				"disks[0].size_bytes",
+				"kairos.version",
+				"persistent.mounted",
+			}))
+			Expect(other.Diff(r)).To(Equal(r.Diff(other)))
+		})
+	})
+
+	Describe("IsInstalled", func() {
+		It("is installed when state is found", func() {
+			r.State = PartitionState{Found: true, Name: "/dev/sda3"}
+			installed, err := r.IsInstalled()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(installed).To(BeTrue())
+
+			r.BootState = LiveCD
+			installed, err = r.IsInstalled()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(installed).To(BeTrue())
+		})
+
+		It("is not installed when running from the livecd without state", func() {
+			r.BootState = LiveCD
+			installed, err := r.IsInstalled()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(installed).To(BeFalse())
+		})
+
+		It("fails when booted from an installed image but state is missing", func() {
+			_, err := r.IsInstalled()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails when the boot state is unknown and state is missing", func() {
+			r.BootState = Unknown
+			_, err := r.IsInstalled()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Clone", func() {
+		BeforeEach(func() {
+			r.Persistent.OtherMountPoints = []string{"/var/lib"}
+			r.Network.Interfaces = []NetworkInterface{{Name: "eth0", IPv4: []string{"10.0.0.2"}}}
+			r.Disks = []DiskState{{Name: "/dev/sda"}}
+			r.ZFSPools = []ZFSPool{{Name: "tank", Datasets: []ZFSDataset{{Name: "tank"}}}}
+			r.LabelDevices = map[string][]string{"COS_PERSISTENT": {"/dev/sda5"}}
+			r.Extra = map[string]PartitionState{"COS_GRUB": {Name: "/dev/sda1", FSFeatures: []string{"journal"}}}
+			r.Timings = map[string]time.Duration{"ghw": time.Second}
+		})
+
+		It("is equal to the original", func() {
+			Expect(r.Clone()).To(Equal(r))
+		})
+
+		It("shares nothing with the original", func() {
+			c := r.Clone()
+			c.Persistent.OtherMountPoints[0] = "/changed"
+			c.Network.Interfaces[0].IPv4[0] = "changed"
+			c.Disks[0].Name = "changed"
+			c.ZFSPools[0].Datasets[0].Name = "changed"
+			c.LabelDevices["COS_PERSISTENT"][0] = "changed"
+			c.Extra["COS_GRUB"].FSFeatures[0] = "changed"
+			c.Timings["ghw"] = 0
+
+			Expect(r.Persistent.OtherMountPoints).To(Equal([]string{"/var/lib"}))
+			Expect(r.Network.Interfaces[0].IPv4).To(Equal([]string{"10.0.0.2"}))
+			Expect(r.Disks[0].Name).To(Equal("/dev/sda"))
+			Expect(r.ZFSPools[0].Datasets[0].Name).To(Equal("tank"))
+			Expect(r.LabelDevices["COS_PERSISTENT"]).To(Equal([]string{"/dev/sda5"}))
+			Expect(r.Extra["COS_GRUB"].FSFeatures).To(Equal([]string{"journal"}))
+			Expect(r.Timings["ghw"]).To(Equal(time.Second))
+		})
+
+		It("keeps a zero value runtime zero", func() {
+			Expect(Runtime{}.Clone()).To(Equal(Runtime{}))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// sysfsSectorSize is the unit sysfs uses for the start and size of block devices, whatever the disk sector size is
+const sysfsSectorSize = 512
+
+// sysfsBlockPath returns the real sysfs directory of a block device, like /sys/devices/.../block/sda/sda5 for /dev/sda5
+func sysfsBlockPath(fs vfs.FS, device string) (string, error) {
+	return CanonicalDeviceWithVFS(fs, filepath.Join("/sys/class/block", filepath.Base(device)))
+}
+
+// readSysfsUint reads a sysfs attribute holding a single unsigned number
+func readSysfsUint(fs vfs.FS, path string) (uint64, error) {
+	dat, err := fs.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(dat)), 10, 64)
+}
+
+// readSysfsString reads a sysfs attribute holding a single string, it's empty if it can't be read
+func readSysfsString(fs vfs.FS, path string) string {
+	dat, err := fs.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(dat))
+}
+
+// partitionExtent returns the start and end, in bytes, of a partition within its disk, and the sysfs dir of the disk
+func partitionExtent(fs vfs.FS, device string) (start, end uint64, diskPath string, err error) {
+	partPath, err := sysfsBlockPath(fs, device)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if !exists(fs, filepath.Join(partPath, "partition")) {
+		return 0, 0, "", fmt.Errorf("%s is not a partition", device)
+	}
+	startSectors, err := readSysfsUint(fs, filepath.Join(partPath, "start"))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	sizeSectors, err := readSysfsUint(fs, filepath.Join(partPath, "size"))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	start = startSectors * sysfsSectorSize
+	return start, start + sizeSectors*sysfsSectorSize, filepath.Dir(partPath), nil
+}
+
+// DeviceChainWithVFS returns the devices stacked below a device mapper device using a vfs so it can be used for
+// tests as well, from the device itself down to the partitions, like the LVM volume, then the dm-crypt mapping
+// and the partition it unlocks. Device mapper devices are named after their mapping, like /dev/mapper/luks-persistent.
+// It's empty for anything else than a device mapper device.
+func DeviceChainWithVFS(fs vfs.FS, device string) []string {
+	path, err := sysfsBlockPath(fs, device)
+	if err != nil || !exists(fs, filepath.Join(path, "dm")) {
+		return nil
+	}
+	chain := []string{}
+	seen := map[string]bool{}
+	var walk func(path string)
+	walk = func(path string) {
+		name := filepath.Base(path)
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if mapping := readSysfsString(fs, filepath.Join(path, "dm", "name")); mapping != "" {
+			chain = append(chain, filepath.Join("/dev/mapper", mapping))
+		} else {
+			chain = append(chain, filepath.Join("/dev", name))
+		}
+		slaves, err := fs.ReadDir(filepath.Join(path, "slaves"))
+		if err != nil {
+			return
+		}
+		for _, s := range slaves {
+			if slave, err := sysfsBlockPath(fs, s.Name()); err == nil {
+				walk(slave)
+			}
+		}
+	}
+	walk(path)
+	return chain
+}
+
+// parentDisks returns the disks a block device lives on, like /dev/sda for /dev/sda5. Device mapper devices
+// are followed down their slaves, so a LVM volume spanning two disks returns both.
+func parentDisks(fs vfs.FS, device string) []string {
+	path, err := sysfsBlockPath(fs, device)
+	if err != nil {
+		return nil
+	}
+	if exists(fs, filepath.Join(path, "partition")) {
+		return []string{filepath.Join("/dev", filepath.Base(filepath.Dir(path)))}
+	}
+	slaves, err := fs.ReadDir(filepath.Join(path, "slaves"))
+	if err != nil || len(slaves) == 0 {
+		return []string{filepath.Join("/dev", filepath.Base(path))}
+	}
+	disks := []string{}
+	seen := map[string]bool{}
+	for _, s := range slaves {
+		for _, d := range parentDisks(fs, s.Name()) {
+			if !seen[d] {
+				seen[d] = true
+				disks = append(disks, d)
+			}
+		}
+	}
+	return disks
+}
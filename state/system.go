@@ -0,0 +1,104 @@
+package state
+
+import "github.com/zcalusic/sysinfo"
+
+// SystemInfo is the hardware and OS info of the node. It mirrors the parts of sysinfo the SDK reports, with the
+// same names, so the report doesn't change when sysinfo does.
+type SystemInfo struct {
+	OS      SystemOS      `yaml:"os" json:"os"`
+	Kernel  SystemKernel  `yaml:"kernel" json:"kernel"`
+	Product SystemProduct `yaml:"product" json:"product"`
+	Board   SystemBoard   `yaml:"board" json:"board"`
+	CPU     SystemCPU     `yaml:"cpu" json:"cpu"`
+	Memory  SystemMemory  `yaml:"memory" json:"memory"`
+}
+
+type SystemOS struct {
+	Name         string `yaml:"name" json:"name,omitempty"`
+	Vendor       string `yaml:"vendor" json:"vendor,omitempty"`
+	Version      string `yaml:"version" json:"version,omitempty"`
+	Release      string `yaml:"release" json:"release,omitempty"`
+	Architecture string `yaml:"architecture" json:"architecture,omitempty"`
+}
+
+type SystemKernel struct {
+	Release      string `yaml:"release" json:"release,omitempty"`
+	Version      string `yaml:"version" json:"version,omitempty"`
+	Architecture string `yaml:"architecture" json:"architecture,omitempty"`
+}
+
+type SystemProduct struct {
+	Name    string `yaml:"name" json:"name,omitempty"`
+	Vendor  string `yaml:"vendor" json:"vendor,omitempty"`
+	Version string `yaml:"version" json:"version,omitempty"`
+	Serial  string `yaml:"serial" json:"serial,omitempty"`
+}
+
+type SystemBoard struct {
+	Name     string `yaml:"name" json:"name,omitempty"`
+	Vendor   string `yaml:"vendor" json:"vendor,omitempty"`
+	Version  string `yaml:"version" json:"version,omitempty"`
+	Serial   string `yaml:"serial" json:"serial,omitempty"`
+	AssetTag string `yaml:"assettag" json:"assettag,omitempty"`
+}
+
+type SystemCPU struct {
+	Vendor  string `yaml:"vendor" json:"vendor,omitempty"`
+	Model   string `yaml:"model" json:"model,omitempty"`
+	Speed   uint   `yaml:"speed" json:"speed,omitempty"`     // CPU clock rate in MHz
+	Cache   uint   `yaml:"cache" json:"cache,omitempty"`     // CPU cache size in KB
+	Cpus    uint   `yaml:"cpus" json:"cpus,omitempty"`       // number of physical CPUs
+	Cores   uint   `yaml:"cores" json:"cores,omitempty"`     // number of physical CPU cores
+	Threads uint   `yaml:"threads" json:"threads,omitempty"` // number of logical (HT) CPU cores
+}
+
+type SystemMemory struct {
+	Type  string `yaml:"type" json:"type,omitempty"`
+	Speed uint   `yaml:"speed" json:"speed,omitempty"` // RAM data rate in MT/s
+	Size  uint   `yaml:"size" json:"size,omitempty"`   // RAM size in MB
+}
+
+// systemInfoFrom converts what sysinfo found into the SDK types
+func systemInfoFrom(si sysinfo.SysInfo) SystemInfo {
+	return SystemInfo{
+		OS: SystemOS{
+			Name:         si.OS.Name,
+			Vendor:       si.OS.Vendor,
+			Version:      si.OS.Version,
+			Release:      si.OS.Release,
+			Architecture: si.OS.Architecture,
+		},
+		Kernel: SystemKernel{
+			Release:      si.Kernel.Release,
+			Version:      si.Kernel.Version,
+			Architecture: si.Kernel.Architecture,
+		},
+		Product: SystemProduct{
+			Name:    si.Product.Name,
+			Vendor:  si.Product.Vendor,
+			Version: si.Product.Version,
+			Serial:  si.Product.Serial,
+		},
+		Board: SystemBoard{
+			Name:     si.Board.Name,
+			Vendor:   si.Board.Vendor,
+			Version:  si.Board.Version,
+			Serial:   si.Board.Serial,
+			AssetTag: si.Board.AssetTag,
+		},
+		CPU: SystemCPU{
+			Vendor:  si.CPU.Vendor,
+			Model:   si.CPU.Model,
+			Speed:   si.CPU.Speed,
+			Cache:   si.CPU.Cache,
+			Cpus:    si.CPU.Cpus,
+			Cores:   si.CPU.Cores,
+			Threads: si.CPU.Threads,
+		},
+		Memory: SystemMemory{
+			Type:  si.Memory.Type,
+			Speed: si.Memory.Speed,
+			Size:  si.Memory.Size,
+		},
+	}
+}
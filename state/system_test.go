@@ -0,0 +1,40 @@
+package state
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zcalusic/sysinfo"
+)
+
+var _ = Describe("system info", func() {
+	It("keeps the sysinfo fields it reports", func() {
+		si := sysinfo.SysInfo{
+			OS:     sysinfo.OS{Name: "openSUSE Leap", Version: "15.5", Architecture: "amd64"},
+			Kernel: sysinfo.Kernel{Release: "5.14.21"},
+			Board:  sysinfo.Board{Vendor: "ASRock", AssetTag: "1234"},
+			CPU:    sysinfo.CPU{Vendor: "GenuineIntel", Cpus: 1, Cores: 4, Threads: 8},
+			Memory: sysinfo.Memory{Size: 8192},
+		}
+		Expect(systemInfoFrom(si)).To(Equal(SystemInfo{
+			OS:     SystemOS{Name: "openSUSE Leap", Version: "15.5", Architecture: "amd64"},
+			Kernel: SystemKernel{Release: "5.14.21"},
+			Board:  SystemBoard{Vendor: "ASRock", AssetTag: "1234"},
+			CPU:    SystemCPU{Vendor: "GenuineIntel", Cpus: 1, Cores: 4, Threads: 8},
+			Memory: SystemMemory{Size: 8192},
+		}))
+
+		// The json stays the same as sysinfo's, so queries don't break
+		theirs, ours := map[string]interface{}{}, map[string]interface{}{}
+		dat, err := json.Marshal(si)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Unmarshal(dat, &theirs)).To(Succeed())
+		dat, err = json.Marshal(systemInfoFrom(si))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Unmarshal(dat, &ours)).To(Succeed())
+		for key := range ours {
+			Expect(ours[key]).To(Equal(theirs[key]), key)
+		}
+	})
+})
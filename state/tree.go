@@ -0,0 +1,131 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// treeNode is a line of Tree and the lines nested below it
+type treeNode struct {
+	text     string
+	children []treeNode
+}
+
+// Tree renders the disks with the partitions found on each and where those are mounted, like lsblk does but from
+// the runtime, so it can go in support bundles as is. Partitions whose disk can't be told are listed under an
+// unknown disk.
+func (r Runtime) Tree() string {
+	type partition struct {
+		roles []string
+		state PartitionState
+	}
+	byDevice := map[string]*partition{}
+	add := func(role string, p PartitionState) {
+		if !p.Found || p.Name == "" {
+			return
+		}
+		if byDevice[p.Name] == nil {
+			byDevice[p.Name] = &partition{state: p}
+		}
+		byDevice[p.Name].roles = append(byDevice[p.Name].roles, role)
+	}
+	add("persistent", r.Persistent)
+	add("recovery", r.Recovery)
+	add("oem", r.OEM)
+	add("state", r.State)
+	for k, p := range r.Extra {
+		add(k, p)
+	}
+	devices := []string{}
+	for d, p := range byDevice {
+		sort.Strings(p.roles)
+		devices = append(devices, d)
+	}
+	sort.Strings(devices)
+
+	partitionNode := func(device string) treeNode {
+		p := byDevice[device]
+		fields := []string{device}
+		if p.state.FilesystemLabel != "" {
+			fields = append(fields, p.state.FilesystemLabel)
+		}
+		fields = append(fields, formatSize(p.state.SizeBytes), fmt.Sprintf("(%s)", strings.Join(p.roles, ", ")))
+		node := treeNode{text: strings.Join(fields, " ")}
+		if p.state.Mounted && p.state.MountPoint != "" {
+			mountpoint := p.state.MountPoint
+			if p.state.IsReadOnly {
+				mountpoint += " ro"
+			}
+			node.children = append(node.children, treeNode{text: mountpoint})
+		}
+		for _, m := range p.state.OtherMountPoints {
+			node.children = append(node.children, treeNode{text: m})
+		}
+		return node
+	}
+
+	fs := r.filesystem()
+	placed := map[string]bool{}
+	disks := []treeNode{}
+	for _, d := range r.Disks {
+		fields := []string{d.Name, formatSize(d.SizeBytes)}
+		if d.Model != "" {
+			fields = append(fields, d.Model)
+		}
+		node := treeNode{text: strings.Join(fields, " ")}
+		for _, device := range devices {
+			for _, parent := range parentDisks(fs, device) {
+				if parent == d.Name {
+					node.children = append(node.children, partitionNode(device))
+					placed[device] = true
+					break
+				}
+			}
+		}
+		disks = append(disks, node)
+	}
+	unknown := treeNode{text: "unknown disk"}
+	for _, device := range devices {
+		if !placed[device] {
+			unknown.children = append(unknown.children, partitionNode(device))
+		}
+	}
+	if len(unknown.children) > 0 {
+		disks = append(disks, unknown)
+	}
+
+	var b strings.Builder
+	for _, d := range disks {
+		b.WriteString(d.text + "\n")
+		writeTree(&b, "", d.children)
+	}
+	return b.String()
+}
+
+// writeTree writes the nodes below the given prefix with the same branches lsblk draws
+func writeTree(b *strings.Builder, prefix string, nodes []treeNode) {
+	for i, n := range nodes {
+		branch, indent := "├─", "│ "
+		if i == len(nodes)-1 {
+			branch, indent = "└─", "  "
+		}
+		b.WriteString(prefix + branch + n.text + "\n")
+		writeTree(b, prefix+indent, n.children)
+	}
+}
+
+// formatSize writes a size in bytes with binary units, like 64.0M, as lsblk does
+func formatSize(bytes uint64) string {
+	const units = "KMGTPE"
+	if bytes < 1024 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	size := float64(bytes)
+	unit := -1
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%c", size, units[unit])
+}
@@ -0,0 +1,40 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Tree", func() {
+	It("nests the partitions and their mountpoints under their disk", func() {
+		fs, cleanup, err := vfst.NewTestFS(sysfsDisk)
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		r := Runtime{
+			Disks:      []DiskState{{Name: "/dev/sda", SizeBytes: 10 * 1024 * 1024 * 1024, Model: "QEMU HARDDISK"}, {Name: "/dev/sdb", SizeBytes: 512}},
+			OEM:        PartitionState{Found: true, Mounted: true, IsReadOnly: true, Name: "/dev/sda1", FilesystemLabel: "COS_OEM", SizeBytes: 64 * 1024 * 1024, MountPoint: "/oem"},
+			State:      PartitionState{Found: true, Name: "/dev/sda2", FilesystemLabel: "COS_STATE", SizeBytes: 4 * 1024 * 1024 * 1024},
+			Persistent: PartitionState{Found: true, Mounted: true, Name: "/dev/sda3", FilesystemLabel: "COS_PERSISTENT", SizeBytes: 5 * 1024 * 1024 * 1024, MountPoint: "/usr/local", OtherMountPoints: []string{"/home"}},
+			Recovery:   PartitionState{Found: true, Name: "/dev/vda2", FilesystemLabel: "COS_RECOVERY", SizeBytes: 3 * 1024 * 1024 * 1024},
+		}.WithFS(fs)
+
+		Expect(r.Tree()).To(Equal(`/dev/sda 10.0G QEMU HARDDISK
+├─/dev/sda1 COS_OEM 64.0M (oem)
+│ └─/oem ro
+├─/dev/sda2 COS_STATE 4.0G (state)
+└─/dev/sda3 COS_PERSISTENT 5.0G (persistent)
+  ├─/usr/local
+  └─/home
+/dev/sdb 512B
+unknown disk
+└─/dev/vda2 COS_RECOVERY 3.0G (recovery)
+`))
+	})
+
+	It("is empty when nothing was found", func() {
+		Expect(Runtime{}.Tree()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,47 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twpayne/go-vfs/v4"
+)
+
+// DetectUptimeWithVFS reads how long the system has been up from /proc/uptime and when it booted from the btime
+// of /proc/stat, using a vfs so it can be used for tests as well. If btime is missing the boot time is derived
+// from the uptime instead.
+func DetectUptimeWithVFS(fs vfs.FS) (uptime time.Duration, bootTime time.Time, err error) {
+	dat, err := fs.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	fields := strings.Fields(string(dat))
+	if len(fields) == 0 {
+		return 0, time.Time{}, fmt.Errorf("empty /proc/uptime")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("parsing /proc/uptime: %w", err)
+	}
+	uptime = time.Duration(seconds * float64(time.Second))
+
+	bootTime = time.Now().Add(-uptime).Truncate(time.Second)
+	if stat, err := fs.ReadFile("/proc/stat"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(stat))
+		for scanner.Scan() {
+			btime, found := strings.CutPrefix(scanner.Text(), "btime ")
+			if !found {
+				continue
+			}
+			if epoch, err := strconv.ParseInt(strings.TrimSpace(btime), 10, 64); err == nil {
+				bootTime = time.Unix(epoch, 0)
+			}
+			break
+		}
+	}
+	return uptime, bootTime, nil
+}
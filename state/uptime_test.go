@@ -0,0 +1,46 @@
+package state_test
+
+import (
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("DetectUptimeWithVFS", func() {
+	It("reads the uptime and the boot time", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/proc/uptime": "3725.42 14200.10\n",
+			"/proc/stat":   "cpu  10 0 20 30 0 0 0 0 0 0\nctxt 1234\nbtime 1696156800\nprocesses 42\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		uptime, bootTime, err := DetectUptimeWithVFS(fs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uptime).To(Equal(3725*time.Second + 420*time.Millisecond))
+		Expect(bootTime.Equal(time.Unix(1696156800, 0))).To(BeTrue())
+	})
+
+	It("derives the boot time from the uptime without btime", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/uptime": "60.00 120.00\n"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		uptime, bootTime, err := DetectUptimeWithVFS(fs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uptime).To(Equal(time.Minute))
+		Expect(time.Since(bootTime)).To(BeNumerically("~", time.Minute, 2*time.Second))
+	})
+
+	It("fails without /proc/uptime", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/stat": "btime 1696156800\n"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		_, _, err = DetectUptimeWithVFS(fs)
+		Expect(err).To(HaveOccurred())
+	})
+})
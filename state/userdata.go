@@ -0,0 +1,58 @@
+package state
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	UserDataNoCloud    = "nocloud"
+	UserDataDatasource = "datasource"
+	UserDataOEM        = "oem"
+)
+
+// userDataSeeds are where the userdata of each source ends up, in the order they are checked
+var userDataSeeds = []struct{ source, path string }{
+	{UserDataNoCloud, "/run/cidata/user-data"},
+	{UserDataNoCloud, "/var/lib/cloud/seed/nocloud/user-data"},
+	{UserDataNoCloud, "/var/lib/cloud/seed/nocloud-net/user-data"},
+	// Where the network datasources, like the cloud metadata services, write the userdata they fetched
+	{UserDataDatasource, "/run/config/userdata"},
+}
+
+// UserDataSource returns where the userdata of the node came from: a NoCloud seed, a datasource or a config file
+// in the oem partition. It's empty when there is none, which is usually why a config didn't apply.
+func (r Runtime) UserDataSource() (string, error) {
+	filesystem := r.filesystem()
+	for _, s := range userDataSeeds {
+		_, err := filesystem.Stat(s.path)
+		if err == nil {
+			return s.source, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	oem := "/oem"
+	if r.OEM.Mounted && r.OEM.MountPoint != "" {
+		oem = r.OEM.MountPoint
+	}
+	entries, err := filesystem.ReadDir(oem)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, e := range entries {
+		// Same files the config collector picks up
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (strings.Contains(e.Name(), "userdata") || ext == ".yaml" || ext == ".yml") {
+			return UserDataOEM, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,41 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("UserDataSource", func() {
+	DescribeTable("finds where the userdata came from",
+		func(files map[string]interface{}, expected string) {
+			fs, cleanup, err := vfst.NewTestFS(files)
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+
+			r := Runtime{OEM: PartitionState{Found: true, Mounted: true, MountPoint: "/mnt/oem"}}.WithFS(fs)
+			source, err := r.UserDataSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(source).To(Equal(expected))
+		},
+		Entry("nothing", map[string]interface{}{"/mnt/oem/README": ""}, ""),
+		Entry("a nocloud seed", map[string]interface{}{"/var/lib/cloud/seed/nocloud/user-data": "#cloud-config"}, UserDataNoCloud),
+		Entry("a datasource", map[string]interface{}{"/run/config/userdata": "#cloud-config"}, UserDataDatasource),
+		Entry("a config in oem", map[string]interface{}{"/mnt/oem/90_custom.yaml": "#cloud-config"}, UserDataOEM),
+		Entry("a seed over the oem config", map[string]interface{}{
+			"/run/cidata/user-data":   "#cloud-config",
+			"/mnt/oem/90_custom.yaml": "#cloud-config",
+		}, UserDataNoCloud),
+	)
+
+	It("looks in /oem when the oem partition is not mounted", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem/userdata": "#cloud-config"})
+		Expect(err).ToNot(HaveOccurred())
+		defer cleanup()
+
+		source, err := Runtime{}.WithFS(fs).UserDataSource()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(UserDataOEM))
+	})
+})
@@ -0,0 +1,33 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// uuidRegexp matches the filesystem UUIDs found in /dev/disk/by-uuid, either a full UUID or the short volume id of
+// vfat, like 1A2B-3C4D
+var uuidRegexp = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{4}-[0-9a-fA-F]{4})$`)
+
+// ValidateUUID checks that the uuid looks like a filesystem UUID, before it's used to look the partition up
+func ValidateUUID(uuid string) error {
+	if !uuidRegexp.MatchString(uuid) {
+		return fmt.Errorf("%q is not a valid filesystem UUID", uuid)
+	}
+	return nil
+}
+
+// DetectPartitionByUUID returns the state of the partition with the given filesystem UUID, like the partitions of
+// the runtime are detected by label. The partition is not found if the UUID is invalid or nothing has it.
+func DetectPartitionByUUID(uuid string, opts ...Option) PartitionState {
+	if ValidateUUID(uuid) != nil {
+		return PartitionState{}
+	}
+	o, err := newOptions(opts...)
+	if err != nil || o.checkTools() != nil {
+		return PartitionState{}
+	}
+	p, _ := lsblkPartition(context.Background(), o.Runner, o.LsblkPath, fmt.Sprintf("/dev/disk/by-uuid/%s", uuid))
+	return p
+}
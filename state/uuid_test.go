@@ -0,0 +1,58 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DetectPartitionByUUID", func() {
+	var commands []string
+	var runner CommandRunner
+
+	BeforeEach(func() {
+		commands = nil
+		runner = func(_ context.Context, command string) (string, error) {
+			commands = append(commands, command)
+			if command != "lsblk /dev/disk/by-uuid/0b8a7e9c-56d2-4a5e-9f1e-2c3d4e5f6a7b -o PATH,FSTYPE,MOUNTPOINT,SIZE,RO,LABEL -J" {
+				return "", errors.New("exit status 32")
+			}
+			return `{"blockdevices": [{"path": "/dev/sda5", "mountpoint": "/usr/local", "fstype": "ext4", "label": "COS_PERSISTENT"}]}`, nil
+		}
+	})
+
+	It("detects the partition with the UUID", func() {
+		part := DetectPartitionByUUID("0b8a7e9c-56d2-4a5e-9f1e-2c3d4e5f6a7b", WithCommandRunner(runner))
+		Expect(part).To(Equal(PartitionState{
+			Found: true, Name: "/dev/sda5", Mounted: true, MountPoint: "/usr/local", Type: "ext4", FilesystemLabel: "COS_PERSISTENT",
+		}))
+	})
+
+	It("is not found when nothing has the UUID", func() {
+		part := DetectPartitionByUUID("1A2B-3C4D", WithCommandRunner(runner))
+		Expect(part.Found).To(BeFalse())
+		Expect(commands).To(HaveLen(1))
+	})
+
+	It("doesn't call lsblk with invalid UUIDs", func() {
+		part := DetectPartitionByUUID("$(reboot)", WithCommandRunner(runner))
+		Expect(part.Found).To(BeFalse())
+		Expect(commands).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ValidateUUID", func() {
+	It("accepts full and short UUIDs", func() {
+		Expect(ValidateUUID("0b8a7e9c-56d2-4a5e-9f1e-2c3d4e5f6a7b")).To(Succeed())
+		Expect(ValidateUUID("1A2B-3C4D")).To(Succeed())
+	})
+
+	It("rejects anything else", func() {
+		Expect(ValidateUUID("")).ToNot(Succeed())
+		Expect(ValidateUUID("0b8a7e9c-56d2-4a5e-9f1e")).ToNot(Succeed())
+		Expect(ValidateUUID("1A2B-3C4D; reboot")).ToNot(Succeed())
+	})
+})
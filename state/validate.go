@@ -0,0 +1,65 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ExpectedPersistentMountpoint is where the persistent partition is mounted on a standard Kairos layout
+const ExpectedPersistentMountpoint = "/usr/local"
+
+// validators are run by Validate, each returns an error describing what is off with the runtime
+var validators = []func(r Runtime) error{
+	validatePersistentMountpoint,
+	validateLabelConflicts,
+}
+
+// PersistentMountpoint returns where the persistent partition is actually mounted
+func (r Runtime) PersistentMountpoint() (string, error) {
+	if !r.Persistent.Mounted || r.Persistent.MountPoint == "" {
+		return "", fmt.Errorf("persistent partition is not mounted")
+	}
+	return r.Persistent.MountPoint, nil
+}
+
+// Validate checks the runtime against the assumptions the rest of Kairos makes about the layout
+// and returns all the mismatches found
+func (r Runtime) Validate() error {
+	var errs error
+	for _, v := range validators {
+		if err := v(r); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+func validatePersistentMountpoint(r Runtime) error {
+	mountpoint, err := r.PersistentMountpoint()
+	if err != nil {
+		// nothing to check, e.g. when booting from the livecd
+		return nil
+	}
+	if mountpoint != ExpectedPersistentMountpoint {
+		return fmt.Errorf("persistent partition is mounted at %s instead of %s", mountpoint, ExpectedPersistentMountpoint)
+	}
+	return nil
+}
+
+func validateLabelConflicts(r Runtime) error {
+	conflicts := r.LabelConflicts()
+	labels := []string{}
+	for label := range conflicts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var errs error
+	for _, label := range labels {
+		errs = multierror.Append(errs, fmt.Errorf("label %s found on several devices: %s", label, strings.Join(conflicts[label], ", ")))
+	}
+	return errs
+}
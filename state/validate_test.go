@@ -0,0 +1,46 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	Describe("PersistentMountpoint", func() {
+		It("returns where persistent is mounted", func() {
+			r := Runtime{Persistent: PartitionState{Found: true, Mounted: true, MountPoint: "/var/persistent"}}
+			mountpoint, err := r.PersistentMountpoint()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mountpoint).To(Equal("/var/persistent"))
+		})
+
+		It("fails if persistent is not mounted", func() {
+			_, err := Runtime{Persistent: PartitionState{Found: true}}.PersistentMountpoint()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("passes on the standard layout", func() {
+		r := Runtime{Persistent: PartitionState{Found: true, Mounted: true, MountPoint: ExpectedPersistentMountpoint}}
+		Expect(r.Validate()).To(Succeed())
+	})
+
+	It("passes when persistent is not mounted", func() {
+		Expect(Runtime{BootState: LiveCD}.Validate()).To(Succeed())
+	})
+
+	It("flags persistent mounted somewhere else", func() {
+		r := Runtime{Persistent: PartitionState{Found: true, Mounted: true, MountPoint: "/var/persistent"}}
+		err := r.Validate()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("/var/persistent instead of /usr/local"))
+	})
+
+	It("flags labels found on several devices", func() {
+		r := Runtime{LabelDevices: map[string][]string{"COS_STATE": {"/dev/sda2", "/dev/sdb2"}}}
+		err := r.Validate()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("COS_STATE found on several devices: /dev/sda2, /dev/sdb2"))
+	})
+})
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// providerSuffixes start the part of a Kairos version naming the bundled provider, like -k3sv1.27.3-k3s1
+var providerSuffixes = []string{"k3s", "k0s"}
+
+// kairosVersion is a parsed Kairos version, the build metadata and provider suffix are dropped as they don't
+// take part in the precedence
+type kairosVersion struct {
+	core [3]uint64
+	pre  []string
+}
+
+// CompareVersion compares the Kairos version against another one following semver, returning -1, 0 or 1 when
+// it's lower, equal or higher. The v prefix is optional and provider suffixes like -k3sv1.27.3-k3s1 are ignored,
+// so the version from a standard or a provider image can be compared against a release.
+func (k Kairos) CompareVersion(other string) (int, error) {
+	a, err := parseKairosVersion(k.Version)
+	if err != nil {
+		return 0, err
+	}
+	b, err := parseKairosVersion(other)
+	if err != nil {
+		return 0, err
+	}
+	return compareKairosVersions(a, b), nil
+}
+
+func parseKairosVersion(s string) (kairosVersion, error) {
+	v := kairosVersion{}
+	trimmed := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	trimmed, _, _ = strings.Cut(trimmed, "+")
+	core, pre, hasPre := strings.Cut(trimmed, "-")
+
+	parts := strings.Split(core, ".")
+	if core == "" || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q: %w", s, err)
+		}
+		v.core[i] = n
+	}
+
+	if hasPre {
+	pieces:
+		for _, piece := range strings.Split(pre, "-") {
+			for _, p := range providerSuffixes {
+				if strings.HasPrefix(piece, p) {
+					break pieces
+				}
+			}
+			v.pre = append(v.pre, strings.Split(piece, ".")...)
+		}
+	}
+	return v, nil
+}
+
+func compareKairosVersions(a, b kairosVersion) int {
+	for i := range a.core {
+		if a.core[i] != b.core[i] {
+			return compareUint(a.core[i], b.core[i])
+		}
+	}
+	// A release is higher than any of its pre-releases
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		if c := comparePreRelease(a.pre[i], b.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(a.pre)), uint64(len(b.pre)))
+}
+
+// comparePreRelease compares numeric identifiers numerically, and they are lower than alphanumeric ones
+func comparePreRelease(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return compareUint(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,38 @@
+package state_test
+
+import (
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompareVersion", func() {
+	DescribeTable("compares versions",
+		func(version, other string, expected int) {
+			c, err := Kairos{Version: version}.CompareVersion(other)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c).To(Equal(expected))
+		},
+		Entry("equal", "v2.4.3", "v2.4.3", 0),
+		Entry("without the v prefix", "v2.4.3", "2.4.3", 0),
+		Entry("lower patch", "v2.4.3", "v2.4.10", -1),
+		Entry("higher minor", "v2.10.0", "v2.9.9", 1),
+		Entry("missing parts count as zero", "v2.4", "v2.4.0", 0),
+		Entry("release over pre-release", "v2.4.0", "v2.4.0-rc1", 1),
+		Entry("pre-release below release", "v2.4.0-beta.2", "v2.4.0", -1),
+		Entry("pre-releases", "v2.4.0-alpha", "v2.4.0-beta", -1),
+		Entry("numeric pre-release identifiers", "v2.4.0-rc.10", "v2.4.0-rc.9", 1),
+		Entry("provider suffix", "v2.4.3-k3sv1.27.3-k3s1", "v2.4.3", 0),
+		Entry("provider suffix on a pre-release", "v2.4.0-rc2-k3sv1.27.3-k3s1", "v2.4.0-rc1", 1),
+		Entry("build metadata", "v2.4.3+20231002", "v2.4.3", 0),
+	)
+
+	It("fails on invalid versions", func() {
+		_, err := Kairos{Version: "v2.4.3"}.CompareVersion("latest")
+		Expect(err).To(HaveOccurred())
+		_, err = Kairos{}.CompareVersion("v2.4.3")
+		Expect(err).To(HaveOccurred())
+		_, err = Kairos{Version: "v2.4.3.1"}.CompareVersion("v2.4.3")
+		Expect(err).To(HaveOccurred())
+	})
+})
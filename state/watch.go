@@ -0,0 +1,86 @@
+package state
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Watch probes the runtime every interval and sends it on the returned channel whenever it changes, starting
+// with the initial probe. The channel is closed once the context is done.
+// To keep it affordable on small nodes, sysinfo can be probed less often with WithSysinfoEvery, and bursts of
+// changes can be coalesced with WithDebounce. Errors probing on a tick are ignored, and the partial runtime is used.
+// Every runtime sent is a Clone, so receivers are free to keep and read it while the watch goes on.
+func Watch(ctx context.Context, interval time.Duration, opts ...Option) (<-chan Runtime, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	initial, err := probe(ctx, o, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Runtime)
+	go func() {
+		defer close(ch)
+		send := func(r Runtime) bool {
+			select {
+			case ch <- r.Clone():
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if !send(initial) {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := initial
+		var pending *Runtime
+		var pendingSince time.Time
+		for tick := 1; ; tick++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			withSystem := tick%o.SysinfoEvery == 0
+			current, _ := probe(ctx, o, withSystem)
+			if !withSystem {
+				current.System = last.System
+			}
+
+			switch {
+			case pending != nil && !runtimeChanged(*pending, current):
+				// Still the same change we are holding back
+			case runtimeChanged(last, current):
+				pending, pendingSince = &current, time.Now()
+			default:
+				// Went back to what was last sent, nothing to report
+				pending = nil
+			}
+			if pending != nil && time.Since(pendingSince) >= o.Debounce {
+				if !send(*pending) {
+					return
+				}
+				last, pending = *pending, nil
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// runtimeChanged compares two runtimes ignoring the bits that change on every probe
+func runtimeChanged(a, b Runtime) bool {
+	a.Timings, b.Timings = nil, nil
+	a.DetectionLog, b.DetectionLog = nil, nil
+	a.Uptime, b.Uptime = 0, 0
+	a.Persistent.IOLatency, b.Persistent.IOLatency = 0, 0
+	// funcs are never deeply equal
+	a.runner, b.runner = nil, nil
+	return !reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,125 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/kairos-io/kairos-sdk/state"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+)
+
+var _ = Describe("Watch", func() {
+	var fs vfs.FS
+	var cleanup func()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	noTools := WithCommandRunner(func(_ context.Context, _ string) (string, error) {
+		return "", errors.New("exit status 1")
+	})
+	noHost := WithHost(nil, SystemInfo{}, nil)
+
+	// osRelease sets the Kairos version the next probes will find
+	osRelease := func(version string) {
+		Expect(fs.WriteFile("/etc/os-release", []byte("KAIROS_FLAVOR=alpine\nKAIROS_VERSION="+version+"\n"), 0o644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{
+			"/proc/cmdline":   "root=LABEL=COS_ACTIVE",
+			"/proc/uptime":    "100.00 200.00\n",
+			"/proc/stat":      "btime 1700000000\n",
+			"/etc/os-release": "KAIROS_FLAVOR=alpine\nKAIROS_VERSION=v2.4.0\n",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		cleanup()
+	})
+
+	It("sends the initial runtime and then the changes", func() {
+		ch, err := Watch(ctx, 10*time.Millisecond, WithFS(fs), noTools, noHost)
+		Expect(err).ToNot(HaveOccurred())
+
+		var r Runtime
+		Eventually(ch).Should(Receive(&r))
+		Expect(r.Kairos.Version).To(Equal("v2.4.0"))
+
+		osRelease("v2.5.0")
+		Eventually(ch).Should(Receive(&r))
+		Expect(r.Kairos.Version).To(Equal("v2.5.0"))
+	})
+
+	It("doesn't send when only the volatile fields change", func() {
+		ch, err := Watch(ctx, 10*time.Millisecond, WithFS(fs), noTools, noHost, WithTimings)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(ch).Should(Receive())
+
+		Expect(fs.WriteFile("/proc/uptime", []byte("160.00 300.00\n"), 0o644)).To(Succeed())
+		Consistently(ch, 200*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("reuses sysinfo between the ticks it's probed on", func() {
+		var mu sync.Mutex
+		probes, sysinfos := 0, 0
+		progress := WithProgress(func(stage string, done, total int) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case stage == "kairos" && done == total:
+				probes++
+			case stage == "sysinfo" && done == total:
+				sysinfos++
+			}
+		})
+		ch, err := Watch(ctx, 10*time.Millisecond, WithFS(fs), noTools, noHost, progress, WithSysinfoEvery(3))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(ch).Should(Receive())
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return probes
+		}).Should(BeNumerically(">=", 7))
+		cancel()
+		Eventually(ch).Should(BeClosed())
+
+		mu.Lock()
+		defer mu.Unlock()
+		// One for the initial probe, then one every third tick
+		Expect(sysinfos).To(BeNumerically(">=", 2))
+		Expect(sysinfos).To(BeNumerically("<=", 1+(probes-1)/3))
+	})
+
+	It("coalesces a burst of changes", func() {
+		ch, err := Watch(ctx, 10*time.Millisecond, WithFS(fs), noTools, noHost, WithDebounce(300*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(ch).Should(Receive())
+
+		for _, v := range []string{"v2.5.0", "v2.6.0", "v2.7.0"} {
+			osRelease(v)
+			time.Sleep(50 * time.Millisecond)
+		}
+		var r Runtime
+		Eventually(ch, 2*time.Second).Should(Receive(&r))
+		Expect(r.Kairos.Version).To(Equal("v2.7.0"))
+		Consistently(ch, 400*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("closes the channel once the context is done", func() {
+		ch, err := Watch(ctx, 10*time.Millisecond, WithFS(fs), noTools, noHost)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(ch).Should(Receive())
+
+		cancel()
+		Eventually(ch).Should(BeClosed())
+	})
+})
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+)
+
+// ZFSPool is a zfs pool along with its top level datasets
+type ZFSPool struct {
+	Name      string `yaml:"name" json:"name"`
+	Health    string `yaml:"health" json:"health"` // Like ONLINE or DEGRADED
+	SizeBytes uint64 `yaml:"size_bytes" json:"size_bytes"`
+	// Datasets are the root dataset of the pool and its direct children
+	Datasets []ZFSDataset `yaml:"datasets,omitempty" json:"datasets,omitempty"`
+}
+
+type ZFSDataset struct {
+	Name           string `yaml:"name" json:"name"`
+	UsedBytes      uint64 `yaml:"used_bytes" json:"used_bytes"`
+	AvailableBytes uint64 `yaml:"available_bytes" json:"available_bytes"`
+	MountPoint     string `yaml:"mount_point,omitempty" json:"mount_point,omitempty"` // Empty for datasets that are not mounted
+}
+
+// parseZFSList splits the tab separated lines printed by the zfs tools with -H into their fields
+func parseZFSList(out string, fields int) [][]string {
+	var rows [][]string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		row := strings.Split(scanner.Text(), "\t")
+		if len(row) == fields {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// detectZFS lists the zfs pools and their top level datasets. Nodes without the zfs tools, or without pools,
+// just get no pools.
+func detectZFS(ctx context.Context, r *Runtime, o *Options) {
+	out, err := o.Runner(ctx, "zpool list -H -p -o name,health,size")
+	if err != nil {
+		return
+	}
+	var pools []ZFSPool
+	index := map[string]int{}
+	for _, row := range parseZFSList(out, 3) {
+		size, _ := strconv.ParseUint(row[2], 10, 64)
+		index[row[0]] = len(pools)
+		pools = append(pools, ZFSPool{Name: row[0], Health: row[1], SizeBytes: size})
+	}
+
+	out, err = o.Runner(ctx, "zfs list -H -p -d 1 -t filesystem -o name,used,avail,mountpoint")
+	if err == nil {
+		for _, row := range parseZFSList(out, 4) {
+			pool, _, _ := strings.Cut(row[0], "/")
+			i, ok := index[pool]
+			if !ok {
+				continue
+			}
+			used, _ := strconv.ParseUint(row[1], 10, 64)
+			avail, _ := strconv.ParseUint(row[2], 10, 64)
+			mountpoint := row[3]
+			if mountpoint == "-" || mountpoint == "none" || mountpoint == "legacy" {
+				mountpoint = ""
+			}
+			pools[i].Datasets = append(pools[i].Datasets, ZFSDataset{Name: row[0], UsedBytes: used, AvailableBytes: avail, MountPoint: mountpoint})
+		}
+	}
+	r.ZFSPools = pools
+}
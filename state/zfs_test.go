@@ -0,0 +1,46 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("zfs detection", func() {
+	It("lists the zfs pools and their datasets", func() {
+		o := DefaultOptions()
+		o.Runner = func(_ context.Context, command string) (string, error) {
+			switch {
+			case strings.HasPrefix(command, "zpool list"):
+				return "tank\tONLINE\t107374182400\nbackup\tDEGRADED\t53687091200\n", nil
+			case strings.HasPrefix(command, "zfs list"):
+				return "tank\t1024\t2048\t/tank\ntank/persistent\t512\t2048\t/usr/local\nbackup\t0\t4096\tnone\n", nil
+			}
+			return "", errors.New("unexpected command")
+		}
+		r := &Runtime{}
+		detectZFS(context.Background(), r, o)
+		Expect(r.ZFSPools).To(Equal([]ZFSPool{
+			{Name: "tank", Health: "ONLINE", SizeBytes: 107374182400, Datasets: []ZFSDataset{
+				{Name: "tank", UsedBytes: 1024, AvailableBytes: 2048, MountPoint: "/tank"},
+				{Name: "tank/persistent", UsedBytes: 512, AvailableBytes: 2048, MountPoint: "/usr/local"},
+			}},
+			{Name: "backup", Health: "DEGRADED", SizeBytes: 53687091200, Datasets: []ZFSDataset{
+				{Name: "backup", AvailableBytes: 4096},
+			}},
+		}))
+	})
+
+	It("finds no zfs pools without the zfs tools", func() {
+		o := DefaultOptions()
+		o.Runner = func(_ context.Context, command string) (string, error) {
+			return "sh: zpool: not found", errors.New("exit status 127")
+		}
+		r := &Runtime{}
+		detectZFS(context.Background(), r, o)
+		Expect(r.ZFSPools).To(BeEmpty())
+	})
+})
@@ -3,6 +3,7 @@ package utils
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"gopkg.in/yaml.v3"
@@ -13,6 +14,7 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/denisbrodbeck/machineid"
 	"github.com/joho/godotenv"
@@ -27,6 +29,19 @@ func SH(c string) (string, error) {
 	return string(o), err
 }
 
+// shWaitDelay is how long SHContext waits for the output to be closed once the shell is killed
+const shWaitDelay = 500 * time.Millisecond
+
+// SHContext is like SH but the command is killed when the context is done
+func SHContext(ctx context.Context, c string) (string, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c)
+	cmd.Env = os.Environ()
+	// Only the shell gets killed on cancellation, don't wait for its children still holding the output open
+	cmd.WaitDelay = shWaitDelay
+	o, err := cmd.CombinedOutput()
+	return string(o), err
+}
+
 func SHInDir(c, dir string, envs ...string) (string, error) {
 	cmd := exec.Command("/bin/sh", "-c", c)
 	cmd.Env = append(os.Environ(), envs...)